@@ -0,0 +1,12 @@
+// Package bakpb holds the gRPC interface of bak serve --grpc, generated
+// from bak.proto, with the client a controller uses to start backups and
+// restores on the machines running it:
+//
+//	conn, err := grpc.NewClient("host:9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	...
+//	agent := bakpb.NewAgentClient(conn)
+//	job, err := agent.StartBackup(ctx, &bakpb.StartBackupRequest{Sources: []string{"src"}})
+//
+// With bak serve --api-token, every call needs the metadata
+// "authorization: Bearer TOKEN".
+package bakpb
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var storeACLs bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&storeACLs, "acls", true, "Store POSIX ACLs of files and directories and give them back on restore")
+}
+
+// POSIX ACLs are extended attributes to Linux, in a binary form naming
+// users and groups by id. Tar archives hold them as text in the records
+// GNU tar and bsdtar use, everywhere else they stay attributes.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+	paxACLAccess    = "SCHILY.acl.access"
+	paxACLDefault   = "SCHILY.acl.default"
+)
+
+// aclRecords maps the attributes of ACLs to the records they are stored in.
+var aclRecords = map[string]string{
+	aclAccessXattr:  paxACLAccess,
+	aclDefaultXattr: paxACLDefault,
+}
+
+func isACLXattr(name string) bool {
+	_, ok := aclRecords[name]
+	return ok
+}
+
+// keepXattr reports whether the attribute name is stored and restored,
+// which --xattrs and, for ACLs and streams, --acls and --ads decide.
+func keepXattr(name string) bool {
+	switch {
+	case isACLXattr(name):
+		return storeACLs
+	case isADSXattr(name):
+		return keepADS()
+	}
+	return storeXattrs
+}
+
+// The tags of ACL entries, and the id of those that have none.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclUndefinedID = 0xffffffff
+	aclVersion     = 2
+)
+
+var aclTags = map[uint16]string{
+	aclUserObj:  "user",
+	aclUser:     "user",
+	aclGroupObj: "group",
+	aclGroup:    "group",
+	aclMask:     "mask",
+	aclOther:    "other",
+}
+
+// aclText turns the binary ACL of an attribute into its text form, like
+// "user::rw-,user:bob:rwx:1001,group::r--,mask::rwx,other::r--". Named
+// entries carry the id as well, for systems that do not know the name.
+func aclText(b []byte) (string, error) {
+	if len(b) < 4 || binary.LittleEndian.Uint32(b) != aclVersion || (len(b)-4)%8 != 0 {
+		return "", errors.New("malformed ACL")
+	}
+	var entries []string
+	for b = b[4:]; len(b) > 0; b = b[8:] {
+		tag := binary.LittleEndian.Uint16(b)
+		perm := binary.LittleEndian.Uint16(b[2:])
+		id := binary.LittleEndian.Uint32(b[4:])
+		kind, ok := aclTags[tag]
+		if !ok {
+			return "", fmt.Errorf("unknown ACL tag %#x", tag)
+		}
+		qualifier := ""
+		switch tag {
+		case aclUser:
+			qualifier = idName(&userNames, int(id), func(id string) (string, error) {
+				u, err := user.LookupId(id)
+				if err != nil {
+					return "", err
+				}
+				return u.Username, nil
+			})
+		case aclGroup:
+			qualifier = idName(&groupNames, int(id), func(id string) (string, error) {
+				g, err := user.LookupGroupId(id)
+				if err != nil {
+					return "", err
+				}
+				return g.Name, nil
+			})
+		}
+		e := kind + ":" + qualifier + ":" + aclPerms(perm)
+		if tag == aclUser || tag == aclGroup {
+			e += ":" + strconv.FormatUint(uint64(id), 10)
+		}
+		entries = append(entries, e)
+	}
+	return strings.Join(entries, ","), nil
+}
+
+func aclPerms(perm uint16) string {
+	b := []byte("---")
+	for i, c := range "rwx" {
+		if perm&(4>>i) != 0 {
+			b[i] = byte(c)
+		}
+	}
+	return string(b)
+}
+
+// aclBinary turns the text form of an ACL back into the binary one. It
+// takes the entries apart by commas or lines, with the tags written out or
+// abbreviated, as other tar implementations write them. Names that exist
+// here win over the ids stored with them.
+func aclBinary(text string) ([]byte, error) {
+	b := binary.LittleEndian.AppendUint32(nil, aclVersion)
+	for _, e := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		e, _, _ = strings.Cut(e, "#")
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		fields := strings.Split(e, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed ACL entry %q", e)
+		}
+		kind, qualifier, perms := fields[0], fields[1], fields[2]
+
+		var tag uint16
+		id := uint32(aclUndefinedID)
+		switch kind {
+		case "user", "u":
+			tag = aclUserObj
+		case "group", "g":
+			tag = aclGroupObj
+		case "mask", "m":
+			tag = aclMask
+		case "other", "o":
+			tag = aclOther
+		default:
+			return nil, fmt.Errorf("malformed ACL entry %q", e)
+		}
+		if qualifier != "" && (tag == aclUserObj || tag == aclGroupObj) {
+			n, ok := aclID(tag, qualifier, fields[3:])
+			if !ok {
+				return nil, fmt.Errorf("ACL entry %q: unknown user or group", e)
+			}
+			tag <<= 1
+			id = n
+		}
+
+		var perm uint16
+		for i, c := range "rwx" {
+			switch {
+			case i < len(perms) && rune(perms[i]) == c:
+				perm |= 4 >> i
+			case i < len(perms) && perms[i] == '-':
+			default:
+				return nil, fmt.Errorf("malformed ACL entry %q", e)
+			}
+		}
+
+		b = binary.LittleEndian.AppendUint16(b, tag)
+		b = binary.LittleEndian.AppendUint16(b, perm)
+		b = binary.LittleEndian.AppendUint32(b, id)
+	}
+	return b, nil
+}
+
+// aclID returns the id of the named entry with qualifier, a name or an id,
+// and the id stored after its permissions if any.
+func aclID(tag uint16, qualifier string, rest []string) (uint32, bool) {
+	cache, lookup := &userIDs, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}
+	if tag == aclGroupObj {
+		cache, lookup = &groupIDs, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}
+	}
+	if id, ok := lookupID(cache, qualifier, lookup); ok {
+		return uint32(id), true
+	}
+	for _, s := range append([]string{qualifier}, rest...) {
+		if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+			return uint32(id), true
+		}
+	}
+	return 0, false
+}
+
+// userNames and groupNames cache the names of ids, "" for ids without a
+// name here.
+var userNames, groupNames sync.Map // map[int]string
+
+// idName returns the name of id, or id itself when it has none.
+func idName(cache *sync.Map, id int, lookup func(string) (string, error)) string {
+	if name, ok := cache.Load(id); ok && name.(string) != "" {
+		return name.(string)
+	} else if ok {
+		return strconv.Itoa(id)
+	}
+	name, err := lookup(strconv.Itoa(id))
+	if err != nil {
+		name = ""
+	}
+	cache.Store(id, name)
+	if name == "" {
+		return strconv.Itoa(id)
+	}
+	return name
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"runtime"
+	"strings"
+)
+
+var storeADS bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&storeADS, "ads", false, "Store the alternate data streams of files on NTFS, like Zone.Identifier, and give them back on restore (Windows)")
+}
+
+// Alternate data streams pass through bak as extended attributes named
+// adsXattrPrefix and the name of the stream. Tar archives hold them in
+// records of their own, other tools would try to set them as attributes.
+const (
+	adsXattrPrefix = "ntfs.ads."
+	paxADSPrefix   = "BAK.ads."
+)
+
+// maxADS is the largest stream stored, they are held in memory like
+// attributes.
+const maxADS = 16 << 20
+
+func isADSXattr(name string) bool {
+	return strings.HasPrefix(name, adsXattrPrefix)
+}
+
+// keepADS reports whether streams are stored and restored. Only Windows
+// has them to give back.
+func keepADS() bool {
+	return storeADS && runtime.GOOS == "windows"
+}
@@ -0,0 +1,474 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupRequest is the body of POST /api/backups.
+type backupRequest struct {
+	Sources []string `json:"sources"`
+	Paths   []string `json:"paths"`
+	Zip     bool     `json:"zip"`
+	// Flags are passed on to bak, e.g. ["--bwlimit", "5M"]. Only those in
+	// apiFlags are taken.
+	Flags []string `json:"flags"`
+}
+
+// apiFlags are the flags a backup started through the API may be given,
+// true for those that take a value. None of them runs commands, reads a
+// config or writes outside the served directory. The config file and the
+// BAK_ variables of the server are not read by jobs either, see run.
+var apiFlags = map[string]bool{
+	"bwlimit":          true,
+	"io-limit":         true,
+	"exclude":          true,
+	"include":          true,
+	"exclude-regex":    true,
+	"include-regex":    true,
+	"exclude-caches":   false,
+	"preset":           true,
+	"type":             true,
+	"max-depth":        true,
+	"max-file-size":    true,
+	"min-file-size":    true,
+	"newer-than":       true,
+	"older-than":       true,
+	"jobs":             true,
+	"keep":             true,
+	"keep-going":       false,
+	"timestamp":        false,
+	"timestamp-format": true,
+}
+
+// apiArgs returns the flags of a request as bak is given them, each as
+// --name or --name=value, or an error for a flag that is not allowed.
+func apiArgs(flags []string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(flags); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(flags[i], "--"), "=")
+		takesValue, ok := apiFlags[name]
+		if !ok || !strings.HasPrefix(flags[i], "--") {
+			return nil, fmt.Errorf("flag %q is not allowed through the API", flags[i])
+		}
+		if takesValue && !hasValue {
+			if i++; i == len(flags) {
+				return nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			value, hasValue = flags[i], true
+		}
+		if hasValue {
+			args = append(args, "--"+name+"="+value)
+		} else {
+			args = append(args, "--"+name)
+		}
+	}
+	return args, nil
+}
+
+// job is a backup or restore started through the API. Every job runs bak
+// as a child process in the served directory, so jobs cannot interfere
+// with each other.
+type job struct {
+	mu       sync.Mutex
+	id       string
+	args     []string
+	outputs  []string
+	status   string
+	exitCode int
+	started  time.Time
+	finished time.Time
+	log      bytes.Buffer
+	// events are the newest progress events of a backup, the first of
+	// them the firstEvent-th of the job.
+	events     []progressEvent
+	firstEvent int
+	// changed is closed and replaced when an event comes in or the job
+	// finishes.
+	changed chan struct{}
+}
+
+// maxJobEvents is the number of progress events kept per job.
+const maxJobEvents = 10000
+
+type jobStatus struct {
+	ID           string     `json:"id"`
+	Args         []string   `json:"args"`
+	Outputs      []string   `json:"outputs"`
+	Status       string     `json:"status"`
+	ExitCode     *int       `json:"exit_code,omitempty"`
+	Started      time.Time  `json:"started"`
+	Finished     *time.Time `json:"finished,omitempty"`
+	BytesWritten int64      `json:"bytes_written"`
+	Log          string     `json:"log"`
+}
+
+type jobManager struct {
+	dir string
+	// token is the bearer token requests need, if set.
+	token string
+	mu    sync.Mutex
+	jobs  []*job
+}
+
+func (m *jobManager) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/backups", m.authorize(m.handleStart))
+	mux.HandleFunc("GET /api/jobs", m.authorize(m.handleList))
+	mux.HandleFunc("GET /api/jobs/{id}", m.authorize(m.handleStatus))
+	mux.HandleFunc("GET /api/jobs/{id}/manifest", m.authorize(m.handleManifest))
+}
+
+// authorize lets requests through to h only with the bearer token, when
+// there is one.
+func (m *jobManager) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// isLoopback reports whether the listen address addr only takes
+// connections from this machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (m *jobManager) handleStart(w http.ResponseWriter, r *http.Request) {
+	// A browser cannot send this type to another site without asking it
+	// first, so no web page can start backups on a server without a token.
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "the request must be application/json")
+		return
+	}
+
+	var req backupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	args, err := backupArgs(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, m.status(m.start(args, req.Paths)))
+}
+
+// backupArgs returns the arguments of the bak process that runs the backup
+// req asks for, or an error for a request that is not allowed.
+func backupArgs(req backupRequest) ([]string, error) {
+	if len(req.Sources) == 0 {
+		return nil, errors.New("sources must not be empty")
+	}
+	for _, src := range req.Sources {
+		// Remote sources would have the server run ssh.
+		if strings.HasPrefix(src, "-") || remoteSourcePattern.MatchString(src) {
+			return nil, fmt.Errorf("source %q is not allowed through the API", src)
+		}
+	}
+
+	args, err := apiArgs(req.Flags)
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{"--no-config"}, args...)
+	for _, p := range req.Paths {
+		// Outputs stay in the served directory.
+		if !filepath.IsLocal(p) || isRemoteOutput(p) {
+			return nil, fmt.Errorf("path %q is not in the served directory", p)
+		}
+		args = append(args, "--path="+p)
+	}
+	if req.Zip {
+		args = append(args, "--zip")
+	}
+	// The outputs come back in the result, with their names filled in,
+	// and the progress events on the descriptor run passes.
+	args = append(args, "--progress=json", "--progress-fd=3", "--json", "--")
+	return append(args, req.Sources...), nil
+}
+
+// start runs bak with args as a new job. Until the job is done, outputs
+// are the outputs it is asked for.
+func (m *jobManager) start(args, outputs []string) *job {
+	m.mu.Lock()
+	j := &job{
+		id:      strconv.Itoa(len(m.jobs) + 1),
+		args:    args,
+		outputs: outputs,
+		status:  "running",
+		started: time.Now(),
+		changed: make(chan struct{}),
+	}
+	m.jobs = append(m.jobs, j)
+	m.mu.Unlock()
+
+	go m.run(j)
+	return j
+}
+
+func (m *jobManager) run(j *job) {
+	exe, err := os.Executable()
+	if err != nil {
+		j.done(err)
+		return
+	}
+
+	cmd := exec.Command(exe, j.args...)
+	cmd.Dir = m.dir
+	// Like the config, the BAK_ variables could set hooks or outputs.
+	cmd.Env = []string{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &lockedWriter{mu: &j.mu, w: &j.log}
+	events, w, err := os.Pipe()
+	if err != nil {
+		j.done(err)
+		return
+	}
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		events.Close()
+		j.done(err)
+		return
+	}
+	j.readEvents(events)
+	err = cmd.Wait()
+
+	var res backupResult
+	if json.Unmarshal(stdout.Bytes(), &res) == nil {
+		j.mu.Lock()
+		j.outputs = []string{}
+		for _, o := range res.Outputs {
+			if o.Error == "" {
+				j.outputs = append(j.outputs, o.Path)
+			}
+		}
+		j.mu.Unlock()
+	}
+	j.done(err)
+}
+
+// readEvents keeps the progress events bak writes to r, one JSON object
+// per line, until it closes it.
+func (j *job) readEvents(r *os.File) {
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var e progressEvent
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		j.mu.Lock()
+		j.events = append(j.events, e)
+		if len(j.events) > maxJobEvents {
+			j.events = slices.Delete(j.events, 0, len(j.events)-maxJobEvents)
+			j.firstEvent++
+		}
+		j.notify()
+		j.mu.Unlock()
+	}
+}
+
+// eventsFrom returns the events kept from the n-th on, the number of the
+// one after them and whether the job is finished, so no more will come,
+// or else a channel closed when something changes. j.mu must not be held.
+func (j *job) eventsFrom(n int) ([]progressEvent, int, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	n = max(n, j.firstEvent)
+	events := slices.Clone(j.events[n-j.firstEvent:])
+	return events, j.firstEvent + len(j.events), !j.finished.IsZero(), j.changed
+}
+
+// notify wakes up those waiting for the job to change. j.mu must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *job) done(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	defer j.notify()
+
+	j.finished = time.Now()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		j.status = "succeeded"
+	case errors.As(err, &exitErr):
+		// Files left out with a warning still make a usable backup.
+		j.exitCode = exitErr.ExitCode()
+		if j.exitCode == exitWarnings {
+			j.status = "succeeded"
+		} else {
+			j.status = "failed"
+		}
+	default:
+		j.exitCode = -1
+		j.status = "failed"
+		j.log.WriteString(err.Error() + "\n")
+	}
+}
+
+// status snapshots a job. While it runs, progress is the size the local
+// outputs have reached so far.
+func (m *jobManager) status(j *job) jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{
+		ID:      j.id,
+		Args:    j.args,
+		Outputs: j.outputs,
+		Status:  j.status,
+		Started: j.started,
+		Log:     j.log.String(),
+	}
+	if !j.finished.IsZero() {
+		finished, code := j.finished, j.exitCode
+		s.Finished = &finished
+		s.ExitCode = &code
+	}
+	for _, o := range j.outputs {
+		if info, err := os.Stat(m.resolve(o)); err == nil {
+			s.BytesWritten += info.Size()
+		}
+	}
+	return s
+}
+
+func (m *jobManager) resolve(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+func (m *jobManager) find(id string) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range m.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (m *jobManager) handleList(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	jobs := append([]*job{}, m.jobs...)
+	m.mu.Unlock()
+
+	statuses := []jobStatus{}
+	for _, j := range jobs {
+		statuses = append(statuses, m.status(j))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (m *jobManager) handleStatus(w http.ResponseWriter, r *http.Request) {
+	j := m.find(r.PathValue("id"))
+	if j == nil {
+		writeJSONError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	writeJSON(w, http.StatusOK, m.status(j))
+}
+
+// handleManifest lists the entries of the archive a finished job wrote to
+// the local disk.
+func (m *jobManager) handleManifest(w http.ResponseWriter, r *http.Request) {
+	j := m.find(r.PathValue("id"))
+	if j == nil {
+		writeJSONError(w, http.StatusNotFound, "no such job")
+		return
+	}
+
+	s := m.status(j)
+	if s.Status != "succeeded" {
+		writeJSONError(w, http.StatusConflict, "job has not succeeded")
+		return
+	}
+
+	for _, o := range s.Outputs {
+		if !isArchiveName(o) || strings.Contains(o, ":") && !filepath.IsAbs(o) {
+			continue
+		}
+
+		entries, err := archiveEntries(m.resolve(o))
+		if entries == nil {
+			entries = []archiveEntry{}
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"archive": o, "entries": entries})
+		return
+	}
+	writeJSONError(w, http.StatusNotFound, "job has no local archive")
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
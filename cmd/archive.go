@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// errStopWalk ends walkArchive early without reporting an error.
+var errStopWalk = errors.New("stop walk")
+
+// archiveEntry describes one member of a backup archive.
+type archiveEntry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// Link is the entry a hard link points to.
+	Link string `json:"link,omitempty"`
+}
+
+// isArchiveName reports whether name looks like an archive bak creates.
+func isArchiveName(name string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkArchive calls fn for every entry of the tar or zip archive at path,
+// with a reader for its contents. Tar archives may be compressed.
+func walkArchive(path string, fn func(e archiveEntry, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if sniffFormat(br) == formatZip {
+		return walkZip(path, fn)
+	}
+
+	r, done, err := tarStream(br)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e := archiveEntry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			e.Link = hdr.Linkname
+		}
+		if err := fn(e, tr); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func walkZip(path string, fn func(e archiveEntry, r io.Reader) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		e := archiveEntry{
+			Name:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+		}
+		err = fn(e, rc)
+		rc.Close()
+		if err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns when the file fi describes was last read.
+func accessTime(fi fs.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(st.Atimespec.Unix())
+}
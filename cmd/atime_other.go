@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package cmd
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime returns the zero time, which leaves access times as they are;
+// there is no portable way to read them here.
+func accessTime(fi fs.FileInfo) time.Time {
+	return time.Time{}
+}
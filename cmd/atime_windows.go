@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns when the file fi describes was last read.
+func accessTime(fi fs.FileInfo) time.Time {
+	d, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, d.LastAccessTime.Nanoseconds())
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import "fmt"
+
+var (
+	maxTotalSize     string
+	maxTotalSizeWarn bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&maxTotalSize, "max-total-size", "", "Refuse to start when the selected files add up to more than this, e.g. 50G (local sources only)")
+	rootCmd.PersistentFlags().BoolVar(&maxTotalSizeWarn, "max-total-size-warn", false, "Only warn when --max-total-size is exceeded")
+}
+
+// checkTotalSize adds up the files that would be archived and compares them
+// against --max-total-size before anything is written.
+func checkTotalSize(sources []string) error {
+	if maxTotalSize == "" {
+		return nil
+	}
+	limit, err := parseSize(maxTotalSize)
+	if err != nil {
+		return fmt.Errorf("--max-total-size: %w", err)
+	}
+
+	files, total, err := selectedSize(sources)
+	if err != nil {
+		return err
+	}
+	if total <= limit {
+		return nil
+	}
+
+	msg := fmt.Sprintf("the %d selected files take %s, more than the --max-total-size of %s", files, formatSize(total), formatSize(limit))
+	if maxTotalSizeWarn {
+		printWarning("%s", msg)
+		return nil
+	}
+	return fmt.Errorf("%s, nothing was written", msg)
+}
+
+// scanned is the outcome of the walk done by selectedSize.
+var scanned struct {
+	done  bool
+	files int
+	total int64
+	err   error
+}
+
+// selectedSize returns the number and total size of the files a backup of
+// the local sources archives. The sources are walked only once for all the
+// checks that need to know.
+func selectedSize(sources []string) (int, int64, error) {
+	if !scanned.done {
+		defer phase("scan")()
+		files, total, err := collectFiles(sources)
+		scanned.done, scanned.files, scanned.total, scanned.err = true, len(files), total, err
+	}
+	return scanned.files, scanned.total, scanned.err
+}
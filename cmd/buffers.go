@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var blockSizeFlag string
+
+// blockSize is how much data is read and written at once when copying file
+// contents.
+var blockSize = 1 << 20
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&blockSizeFlag, "block-size", "1M", "Read and write file contents in blocks of this size, e.g. 4M for spinning disks or NFS")
+}
+
+func setBlockSize() error {
+	n, err := parseSize(blockSizeFlag)
+	if err != nil {
+		return fmt.Errorf("--block-size: %w", err)
+	}
+	if n < 4<<10 || n > 64<<20 {
+		return fmt.Errorf("--block-size: %s is not between 4K and 64M", blockSizeFlag)
+	}
+	blockSize = int(n)
+	return nil
+}
+
+// bufferPool keeps the copy buffers, so that every file does not allocate
+// a large one of its own.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, blockSize)
+		return &b
+	},
+}
+
+// copyBlocks copies src to dst like io.Copy, but always in blocks of
+// --block-size, taken from a pool.
+func copyBlocks(dst io.Writer, src io.Reader) (int64, error) {
+	bp := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bp)
+	if len(*bp) != blockSize {
+		*bp = make([]byte, blockSize)
+	}
+	// Hiding ReaderFrom and WriterTo makes io.CopyBuffer use the buffer.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *bp)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var strict bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false, "Fail instead of warning when entries differ only in case or Unicode normalization, which macOS and Windows cannot restore side by side")
+}
+
+// caseArchive notices entries whose names differ only in case or in their
+// Unicode normalization, which macOS does not tell apart either.
+type caseArchive struct {
+	archiveWriter
+	// seen holds the first name stored for each lower case NFC name.
+	seen map[string]string
+}
+
+func newCaseArchive(aw archiveWriter) caseArchive {
+	return caseArchive{aw, make(map[string]string)}
+}
+
+func (a caseArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	name := strings.TrimSuffix(hdr.Name, "/")
+	key := strings.ToLower(norm.NFC.String(name))
+	if prev, ok := a.seen[key]; !ok {
+		a.seen[key] = name
+	} else if prev != name {
+		err := fmt.Errorf("%s and %s differ only in case or Unicode normalization, restoring onto a case-insensitive filesystem keeps one of them", prev, name)
+		if strict {
+			return withExitCode(exitSource, err)
+		}
+		printWarning("%v", err)
+	}
+	return a.archiveWriter.writeEntry(hdr, r)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	force     bool
+	noClobber bool
+)
+
+var errExists = errors.New("already exists, use --force to overwrite it")
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&force, "force", "f", false, "Overwrite existing outputs without asking")
+	rootCmd.PersistentFlags().BoolVar(&noClobber, "no-clobber", false, "Never overwrite existing outputs, without asking")
+}
+
+func checkClobberFlags() error {
+	if force && noClobber {
+		return errors.New("--force and --no-clobber cannot be used together")
+	}
+	return nil
+}
+
+// checkClobber decides whether the local output dst may be written. An
+// existing file is only overwritten with --force or when the user agrees
+// to it.
+func checkClobber(dst string) error {
+	if force {
+		return nil
+	}
+	if _, err := os.Lstat(longPath(dst)); err != nil {
+		return nil
+	}
+	if !noClobber && ask(fmt.Sprintf("%s exists, overwrite it?", dst)) {
+		return nil
+	}
+	return errExists
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fastCopy clones src with clonefile, which shares the blocks of the file
+// on APFS instead of copying them. As clonefile creates the file itself, the
+// clone replaces dst's file under its name. handled is false when cloning
+// does not work here and the contents must be copied the usual way.
+func fastCopy(dst, src *os.File) (n int64, handled bool, err error) {
+	info, err := src.Stat()
+	if err != nil {
+		return 0, false, nil
+	}
+	tmp := dst.Name() + ".clone"
+	if err := unix.Clonefile(src.Name(), tmp, unix.CLONE_NOFOLLOW); err != nil {
+		return 0, false, nil
+	}
+	if err := os.Rename(tmp, dst.Name()); err != nil {
+		os.Remove(tmp)
+		return 0, false, nil
+	}
+	return info.Size(), true, nil
+}
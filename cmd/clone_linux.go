@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyRangeChunk is how much copy_file_range copies between checks for an
+// interruption.
+const copyRangeChunk = 64 << 20
+
+// fastCopy copies src to dst inside the kernel: as a reflink on
+// filesystems that share blocks between files, like btrfs and XFS, else
+// with copy_file_range. handled is false when neither works here and the
+// contents must be copied the usual way.
+func fastCopy(dst, src *os.File) (n int64, handled bool, err error) {
+	info, err := src.Stat()
+	if err != nil {
+		return 0, false, nil
+	}
+	if unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())) == nil {
+		return info.Size(), true, nil
+	}
+	if mayBeSparse(info) {
+		// copy_file_range may fill in the holes, the usual copy keeps them.
+		return 0, false, nil
+	}
+
+	for {
+		if interrupted.Load() {
+			return n, true, errInterrupted
+		}
+		c, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, copyRangeChunk, 0)
+		if err != nil {
+			if n == 0 {
+				return 0, false, nil
+			}
+			return n, true, err
+		}
+		if c == 0 {
+			return n, true, nil
+		}
+		n += int64(c)
+	}
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFile string
+	noConfig   bool
+)
+
+// flagOrigins tells for the flags the environment or config set where
+// their value came from, for config show.
+var flagOrigins = map[string]string{}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Read defaults for the flags from this file instead of bak/config.yaml in the user config directory")
+	rootCmd.PersistentFlags().BoolVar(&noConfig, "no-config", false, "Do not read a config file")
+	rootCmd.PersistentPreRunE = loadConfig
+}
+
+// defaultConfigPath is where the config file is looked for without
+// --config.
+func defaultConfigPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// configPath returns the config file bak reads.
+func configPath() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	return defaultConfigPath()
+}
+
+// readConfig returns the parsed config file and its path, a nil document
+// when there is none to read.
+func readConfig() (*yaml.Node, string, error) {
+	if noConfig {
+		return nil, "", nil
+	}
+	path, err := configPath()
+	if err != nil {
+		return nil, "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && configFile == "" {
+		return nil, path, nil
+	}
+	if err != nil {
+		return nil, path, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, path, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, path, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, path, fmt.Errorf("%s:%d: the config must be a mapping of flag names to values", path, root.Line)
+	}
+	return root, path, nil
+}
+
+// loadConfig gives the flags of cmd not set on the command line the
+// values the environment and then the config file have for them. Its keys
+// are the long flag names, besides sources, the pre and post hooks and
+// profiles. The values of a --profile come first.
+func loadConfig(cmd *cobra.Command, args []string) error {
+	// The environment may say which config to read, so it goes first.
+	if err := applyEnv(cmd.Flags()); err != nil {
+		cmd.SilenceUsage = true
+		return withExitCode(exitUsage, err)
+	}
+
+	root, path, err := readConfig()
+	if err == nil && root == nil && profileName != "" {
+		err = fmt.Errorf("no profile %q without a config file", profileName)
+	}
+	if err == nil && root != nil {
+		err = checkConfig(root, path)
+	}
+	if err == nil && root != nil {
+		err = applyProfile(cmd.Flags(), root, path)
+	}
+	if err != nil {
+		// A broken config is no reason to show the usage.
+		cmd.SilenceUsage = true
+		return withExitCode(exitUsage, fmt.Errorf("config: %w", err))
+	}
+	return nil
+}
+
+// applyProfile applies the --profile, if any, and then the rest of the
+// config root.
+func applyProfile(fs *pflag.FlagSet, root *yaml.Node, path string) error {
+	if profileName != "" {
+		names, profiles, err := profileChain(root, profileName, path)
+		if err != nil {
+			return err
+		}
+		for i, p := range profiles {
+			if err := applyConfig(fs, p, path, "profile "+names[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return applyConfig(fs, root, path, "config")
+}
+
+// applyConfig sets the flags in fs that the mapping m has values for,
+// unless they have one already, and takes its sources. origin names m.
+func applyConfig(fs *pflag.FlagSet, m *yaml.Node, path, origin string) error {
+	if err := applySources(m, path); err != nil {
+		return err
+	}
+	if err := applyHooks(m, path); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		switch key.Value {
+		case "sources", "profiles", "pre", "post", "extends":
+			continue
+		}
+		f := fs.Lookup(key.Value)
+		if f == nil || f.Changed {
+			// A flag of another command, or a key validateConfig warned
+			// about.
+			continue
+		}
+		if err := setFlag(f, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, value.Line, key.Value, err)
+		}
+		flagOrigins[f.Name] = origin
+	}
+	return nil
+}
+
+// configValues returns the config value v, a scalar or a list of them,
+// with ~ expanded.
+func configValues(v *yaml.Node) ([]string, error) {
+	var values []string
+	switch v.Kind {
+	case yaml.ScalarNode:
+		values = []string{v.Value}
+	case yaml.SequenceNode:
+		for _, item := range v.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a plain value", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+	default:
+		return nil, errors.New("expected a value or a list of values")
+	}
+	for i, s := range values {
+		values[i] = expandHome(s)
+	}
+	return values, nil
+}
+
+// setFlag sets f to the config value v, a scalar or, for flags that take
+// several values, a list.
+func setFlag(f *pflag.Flag, v *yaml.Node) error {
+	values, err := configValues(v)
+	if err != nil {
+		return err
+	}
+	return setValues(f, values)
+}
+
+// setValues sets f to values, which must be one unless f takes several.
+func setValues(f *pflag.Flag, values []string) error {
+	var err error
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		err = sv.Replace(values)
+	} else if len(values) != 1 {
+		err = errors.New("takes a single value")
+	} else {
+		err = f.Value.Set(values[0])
+	}
+	// Set now, the flag is no longer up for what comes after.
+	f.Changed = err == nil
+	return err
+}
+
+// expandHome expands a leading ~ to the home directory, as a shell would.
+func expandHome(s string) string {
+	if s != "~" && !strings.HasPrefix(s, "~/") {
+		return s
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return s
+	}
+	return filepath.Join(home, s[1:])
+}
+
+// checkConfig validates the config before it is used. Warnings are
+// printed, of the errors the first is returned.
+func checkConfig(root *yaml.Node, path string) error {
+	warnings, errs := validateConfig(root, path)
+	for _, w := range warnings {
+		printWarning("%s", w)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %d more, see bak config validate)", errs[0], len(errs)-1)
+}
+
+// lookupFlag returns the flag called name of any command, nil if there is
+// none.
+func lookupFlag(name string) *pflag.Flag {
+	var found *pflag.Flag
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		if f := c.Flags().Lookup(name); f != nil && found == nil {
+			found = f
+		}
+		if f := c.PersistentFlags().Lookup(name); f != nil && found == nil {
+			found = f
+		}
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(rootCmd)
+	return found
+}
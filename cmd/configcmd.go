@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Create, edit and show the config file",
+	// The config commands must work with a broken config file, they are
+	// how it gets fixed.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a config file with commented examples, where bak looks for it",
+	Args:  cobra.NoArgs,
+	Run:   runConfigInit,
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open the config file in $VISUAL or $EDITOR, creating it first if needed",
+	Args:  cobra.NoArgs,
+	Run:   runConfigEdit,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the settings a backup would run with, from the config, the --profile and the flags given",
+	Args:  cobra.NoArgs,
+	Run:   runConfigShow,
+}
+
+func init() {
+	configCmd.AddCommand(configInitCmd, configEditCmd, configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// configTemplate is what config init writes.
+const configTemplate = `# bak config file. The keys are the long names of the flags, the values
+# are what they default to. Flags given on the command line win, then
+# BAK_ environment variables, like BAK_KEEP for --keep.
+#
+# path: ~/backups/{basename}-{date}.tar.gz
+# zip: false
+# jobs: 4
+# keep: 10
+# exclude:
+#   - node_modules
+#   - "*.tmp"
+#
+# Sources are backed up when bak is run without any.
+#
+# sources:
+#   - ~/Documents
+#
+# A profile is run with --profile NAME. Its values win over the ones above.
+#
+# profiles:
+#   photos:
+#     sources: [~/Pictures]
+#     path: /mnt/backup/photos-{date}.tar.gz
+#     exclude: ["*.xmp"]
+#     keep: 5
+#
+# A profile can extend another one, then it only sets what differs.
+#
+#   raw:
+#     extends: photos
+#     sources: [~/Pictures/raw]
+#
+# pre and post are shell commands run before and after a backup; a failed
+# pre command stops it. They can be given for all runs or per profile, and
+# can use {{.OutputPath}}, {{.OutputPaths}}, {{.BytesWritten}},
+# {{.Duration}} and {{.ErrorCount}}. The paths come quoted already, so do
+# not put them in quotes.
+#
+#   databases:
+#     sources: [db.sql]
+#     pre: pg_dump mydb > db.sql
+#     post:
+#       - rm db.sql
+#       - ls -l {{.OutputPaths}}
+#       - curl -fsS -d "{{.BytesWritten}} bytes, {{.ErrorCount}} errors" "$WEBHOOK"
+`
+
+func runConfigInit(cmd *cobra.Command, args []string) {
+	path, err := configPath()
+	if err == nil {
+		err = writeConfigTemplate(path)
+	}
+	if err != nil {
+		reportError(err)
+		return
+	}
+	printSuccess("Config written to %s", path)
+}
+
+// writeConfigTemplate writes configTemplate to path, which must not exist
+// yet unless --force is given.
+func writeConfigTemplate(path string) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return withExitCode(exitDestination, fmt.Errorf("%s: %w", path, errExists))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	if err := os.WriteFile(path, []byte(configTemplate), 0o644); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	return nil
+}
+
+func runConfigEdit(cmd *cobra.Command, args []string) {
+	if err := editConfig(); err != nil {
+		reportError(err)
+	}
+}
+
+func editConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := writeConfigTemplate(path); err != nil {
+			return err
+		}
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		}
+	}
+	c := exec.Command(editor[0], append(editor[1:], path)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s: %w", editor[0], err)
+	}
+	return nil
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) {
+	if err := loadConfig(cmd, args); err != nil {
+		reportError(err)
+		return
+	}
+	out, err := yaml.Marshal(effectiveConfig(cmd.Flags()))
+	if err != nil {
+		reportError(err)
+		return
+	}
+	os.Stdout.Write(out)
+}
+
+// showHidden are the flags config show leaves out, they only say which
+// config to read.
+var showHidden = map[string]bool{"config": true, "no-config": true, "profile": true, "help": true}
+
+// effectiveConfig returns the flags of fs that are not at their defaults,
+// as a config file, each with where its value came from.
+func effectiveConfig(fs *pflag.FlagSet) *yaml.Node {
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	if configSources != nil {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "sources"}, stringsNode(configSources))
+	}
+	if preHooks != nil {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "pre"}, stringsNode(preHooks))
+	}
+	if postHooks != nil {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "post"}, stringsNode(postHooks))
+	}
+	fs.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || showHidden[f.Name] {
+			return
+		}
+		var v *yaml.Node
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			v = stringsNode(sv.GetSlice())
+		} else {
+			v = &yaml.Node{Kind: yaml.ScalarNode}
+			switch f.Value.Type() {
+			case "bool":
+				v.Tag, v.Value = "!!bool", f.Value.String()
+			case "int", "count":
+				v.Tag, v.Value = "!!int", f.Value.String()
+			default:
+				v.SetString(f.Value.String())
+			}
+		}
+		origin, ok := flagOrigins[f.Name]
+		if !ok {
+			origin = "flag"
+		}
+		// yaml puts the comment of a block sequence on its key.
+		k := &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name}
+		if v.Kind == yaml.SequenceNode {
+			k.LineComment = origin
+		} else {
+			v.LineComment = origin
+		}
+		m.Content = append(m.Content, k, v)
+	})
+	return m
+}
+
+func stringsNode(values []string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, s := range values {
+		item := &yaml.Node{}
+		item.SetString(s)
+		n.Content = append(n.Content, item)
+	}
+	return n
+}
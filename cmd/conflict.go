@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var onConflict string
+
+// The ways of dealing with existing files --on-conflict takes.
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictKeepNewer = "keep-newer"
+	conflictRename    = "rename"
+)
+
+func init() {
+	restoreCmd.Flags().StringVar(&onConflict, "on-conflict", "", "What to do with files that exist already: skip them, overwrite them, keep-newer (only overwrite older ones) or rename (restore beside them as name.1); without it bak asks, see --force and --no-clobber")
+}
+
+func setOnConflict() error {
+	switch onConflict {
+	case "", conflictSkip, conflictOverwrite, conflictKeepNewer, conflictRename:
+		return nil
+	}
+	return fmt.Errorf("--on-conflict: unknown strategy %q, use skip, overwrite, keep-newer or rename", onConflict)
+}
+
+// conflicts counts what --on-conflict did, for the summary.
+var conflicts struct {
+	sync.Mutex
+	skipped, kept, overwritten, renamed int
+}
+
+// resolveConflict returns where an entry last modified at mtime is
+// restored when target exists already, "" when it is left out. Without
+// --on-conflict, existing files are only overwritten the way other outputs
+// are.
+func resolveConflict(target string, mtime time.Time) (string, error) {
+	fi, err := os.Lstat(longPath(target))
+	if err != nil {
+		return target, nil
+	}
+	if onConflict == "" {
+		return target, checkClobber(target)
+	}
+
+	conflicts.Lock()
+	defer conflicts.Unlock()
+	switch onConflict {
+	case conflictSkip:
+		conflicts.skipped++
+		return "", nil
+	case conflictKeepNewer:
+		if !mtime.After(fi.ModTime()) {
+			conflicts.kept++
+			return "", nil
+		}
+	case conflictRename:
+		for i := 1; ; i++ {
+			name := fmt.Sprintf("%s.%d", target, i)
+			if _, err := os.Lstat(longPath(name)); err != nil {
+				conflicts.renamed++
+				return name, nil
+			}
+		}
+	}
+	conflicts.overwritten++
+	return target, nil
+}
+
+// reportConflicts prints what --on-conflict did.
+func reportConflicts() {
+	var parts []string
+	for _, c := range []struct {
+		n    int
+		what string
+	}{
+		{conflicts.overwritten, "overwritten"},
+		{conflicts.skipped, "skipped"},
+		{conflicts.kept, "kept as they were newer"},
+		{conflicts.renamed, "restored under a new name"},
+	} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	if len(parts) > 0 {
+		printInfo("Existing files: %s", strings.Join(parts, ", "))
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+var (
+	debugTiming bool
+	cpuProfile  string
+	memProfile  string
+	traceFile   string
+	pprofAddr   string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&debugTiming, "debug", false, "Log how long each phase of the backup takes and how the number of files read at once is tuned")
+	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpu-profile", "", "Write a CPU profile for go tool pprof to this file")
+	rootCmd.PersistentFlags().StringVar(&memProfile, "mem-profile", "", "Write a heap profile for go tool pprof to this file at the end")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace", "", "Write an execution trace for go tool trace to this file")
+	rootCmd.PersistentFlags().StringVar(&pprofAddr, "pprof", "", "Serve the pprof endpoints on this address while running, e.g. localhost:6060")
+}
+
+// startDebug starts the profiles asked for. The returned function stops
+// them and writes them out.
+func startDebug() (func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if pprofAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(pprofAddr, nil); err != nil {
+				printWarning("--pprof: %v", err)
+			}
+		}()
+		printInfo("Serving pprof on http://%s/debug/pprof/", pprofAddr)
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return stop, fmt.Errorf("--cpu-profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return stop, fmt.Errorf("--cpu-profile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stop()
+			return func() {}, fmt.Errorf("--trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return func() {}, fmt.Errorf("--trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			f.Close()
+		})
+	}
+
+	if memProfile != "" {
+		stops = append(stops, func() {
+			if err := writeHeapProfile(memProfile); err != nil {
+				printWarning("--mem-profile: %v", err)
+			}
+		})
+	}
+	return stop, nil
+}
+
+func writeHeapProfile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	return errors.Join(err, f.Close())
+}
+
+// phase times a phase of the backup for --debug. Call the returned
+// function when the phase ends.
+func phase(name string) func() {
+	if !debugTiming {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		printInfo("Phase %s took %s", name, time.Since(start).Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// The directories bak keeps its files in follow the XDG base directory
+// spec: an XDG_*_HOME variable wins on every system, without one it is
+// the place the system has for such files.
+
+// configDir is where the config file is kept: $XDG_CONFIG_HOME/bak,
+// ~/.config/bak, ~/Library/Application Support/bak or %AppData%\bak.
+func configDir() (string, error) {
+	return userDir("XDG_CONFIG_HOME", os.UserConfigDir)
+}
+
+// dataDir is where the store is kept: $XDG_DATA_HOME/bak,
+// ~/.local/share/bak, ~/Library/Application Support/bak or
+// %LocalAppData%\bak.
+func dataDir() (string, error) {
+	return userDir("XDG_DATA_HOME", func() (string, error) {
+		return systemDir(".local", "share")
+	})
+}
+
+// stateDir is where what a run leaves for the next one is kept:
+// $XDG_STATE_HOME/bak, ~/.local/state/bak, ~/Library/Application
+// Support/bak or %LocalAppData%\bak.
+func stateDir() (string, error) {
+	return userDir("XDG_STATE_HOME", func() (string, error) {
+		return systemDir(".local", "state")
+	})
+}
+
+// userDir returns the bak directory in the one the variable env names,
+// or else in the one fallback returns.
+func userDir(env string, fallback func() (string, error)) (string, error) {
+	// The spec asks to ignore relative paths.
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "bak"), nil
+	}
+	dir, err := fallback()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bak"), nil
+}
+
+// systemDir returns the directory for the data files of programs: elem in
+// the home directory on Unix.
+func systemDir(elem ...string) (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return os.UserConfigDir()
+	case "darwin", "ios":
+		return os.UserConfigDir()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{home}, elem...)...), nil
+}
@@ -0,0 +1,453 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// Chunks of a concurrent session must be a multiple of 4 MiB.
+	dropboxConcurrentUnit = 4 << 20
+	dropboxMaxChunk       = 150 << 20
+)
+
+// dropboxUpload streams an archive into a Dropbox upload session, sending
+// one chunk whenever the buffer fills up.
+//
+// When a previous run was interrupted, the bytes it already uploaded are
+// skipped, hashed and kept in a spool file. If they match, the old session
+// is continued, otherwise a new one starts with the spooled bytes.
+//
+// With --upload-concurrency above 1 a concurrent session is used instead,
+// where chunks are appended in the background and may arrive in any order.
+type dropboxUpload struct {
+	path    string
+	token   *oauthToken
+	buf     []byte
+	session string
+	offset  int64
+	hash    hash.Hash
+	resume  *uploadState
+	skip    int64
+	// spool holds the skipped bytes until they are known to match.
+	spool *os.File
+
+	concurrent bool
+	free       chan []byte
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	err        error
+}
+
+type dropboxCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+func newDropboxUpload(path string) (*dropboxUpload, error) {
+	token, err := dropboxToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	chunkSize := min(uploadChunkSize, dropboxMaxChunk)
+	u := &dropboxUpload{
+		path:       path,
+		token:      token,
+		hash:       sha256.New(),
+		concurrent: uploadConcurrency > 1,
+	}
+
+	if u.concurrent {
+		chunkSize = max(chunkSize/dropboxConcurrentUnit, 1) * dropboxConcurrentUnit
+		u.free = make(chan []byte, uploadConcurrency)
+		for range uploadConcurrency - 1 {
+			u.free <- make([]byte, 0, chunkSize)
+		}
+	} else if st := loadUploadState(u.dest()); st != nil {
+		u.resume = st
+		u.skip = st.Offset
+	}
+
+	u.buf = make([]byte, 0, chunkSize)
+	return u, nil
+}
+
+func (u *dropboxUpload) dest() string {
+	return "dropbox:" + u.path
+}
+
+// dropboxToken reads the credentials from the environment. A refresh token
+// is preferred because short-lived access tokens expire during long uploads.
+func dropboxToken() (*oauthToken, error) {
+	if refresh := os.Getenv("DROPBOX_REFRESH_TOKEN"); refresh != "" {
+		form := url.Values{
+			"refresh_token": {refresh},
+			"client_id":     {os.Getenv("DROPBOX_APP_KEY")},
+		}
+		if secret := os.Getenv("DROPBOX_APP_SECRET"); secret != "" {
+			form.Set("client_secret", secret)
+		}
+		return &oauthToken{refresh: func() (string, time.Duration, error) {
+			return refreshToken("https://api.dropboxapi.com/oauth2/token", form)
+		}}, nil
+	}
+
+	if access := os.Getenv("DROPBOX_ACCESS_TOKEN"); access != "" {
+		return &oauthToken{access: access}, nil
+	}
+
+	return nil, errors.New("dropbox: set DROPBOX_REFRESH_TOKEN and DROPBOX_APP_KEY, or DROPBOX_ACCESS_TOKEN")
+}
+
+func (u *dropboxUpload) Write(p []byte) (int, error) {
+	written := 0
+	if u.skip > 0 {
+		n := int(min(u.skip, int64(len(p))))
+		if err := u.spoolSkipped(p[:n]); err != nil {
+			return 0, err
+		}
+		u.hash.Write(p[:n])
+		u.skip -= int64(n)
+		p = p[n:]
+		written = n
+
+		if u.skip == 0 {
+			if err := u.continueSession(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	for len(p) > 0 {
+		n := min(cap(u.buf)-len(u.buf), len(p))
+		u.buf = append(u.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(u.buf) == cap(u.buf) {
+			if err := u.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (u *dropboxUpload) flush() error {
+	if u.concurrent {
+		return u.flushConcurrent()
+	}
+
+	if u.session == "" {
+		body, err := u.call("upload_session/start", map[string]any{"close": false}, u.buf)
+		if err != nil {
+			return err
+		}
+
+		var resp struct {
+			SessionID string `json:"session_id"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("dropbox: %w", err)
+		}
+		u.session = resp.SessionID
+	} else {
+		arg := map[string]any{
+			"cursor": dropboxCursor{SessionID: u.session, Offset: u.offset},
+			"close":  false,
+		}
+		if _, err := u.call("upload_session/append_v2", arg, u.buf); err != nil && !appended(err, u.offset+int64(len(u.buf))) {
+			u.forgetRejected(err)
+			return err
+		}
+	}
+
+	u.hash.Write(u.buf)
+	u.offset += int64(len(u.buf))
+	u.buf = u.buf[:0]
+
+	saveUploadState(u.dest(), &uploadState{
+		Session: u.session,
+		Offset:  u.offset,
+		Hash:    hex.EncodeToString(u.hash.Sum(nil)),
+	})
+	return nil
+}
+
+// flushConcurrent hands the buffer to a background upload and continues with
+// a free one, waiting for an upload to finish if there is none.
+func (u *dropboxUpload) flushConcurrent() error {
+	if err := u.failed(); err != nil {
+		return err
+	}
+	if u.session == "" {
+		if err := u.startConcurrent(); err != nil {
+			return err
+		}
+	}
+
+	chunk, offset := u.buf, u.offset
+	u.offset += int64(len(chunk))
+	u.buf = (<-u.free)[:0]
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		defer func() { u.free <- chunk }()
+
+		arg := map[string]any{
+			"cursor": dropboxCursor{SessionID: u.session, Offset: offset},
+			"close":  false,
+		}
+		if _, err := u.call("upload_session/append_v2", arg, chunk); err != nil && !appended(err, offset+int64(len(chunk))) {
+			u.mu.Lock()
+			if u.err == nil {
+				u.err = err
+			}
+			u.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (u *dropboxUpload) startConcurrent() error {
+	body, err := u.call("upload_session/start", map[string]any{"close": false, "session_type": "concurrent"}, nil)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("dropbox: %w", err)
+	}
+	u.session = resp.SessionID
+	return nil
+}
+
+func (u *dropboxUpload) failed() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+// spoolSkipped keeps the skipped bytes p, in case the session cannot be
+// continued.
+func (u *dropboxUpload) spoolSkipped(p []byte) error {
+	if u.spool == nil {
+		f, err := os.CreateTemp("", "bak-dropbox-*")
+		if err != nil {
+			return fmt.Errorf("dropbox: %w", err)
+		}
+		u.spool = f
+	}
+	if _, err := u.spool.Write(p); err != nil {
+		return fmt.Errorf("dropbox: %w", err)
+	}
+	return nil
+}
+
+// dropSpool removes the spool file.
+func (u *dropboxUpload) dropSpool() {
+	if u.spool != nil {
+		u.spool.Close()
+		os.Remove(u.spool.Name())
+		u.spool = nil
+	}
+}
+
+// continueSession picks up the interrupted session once all the bytes it
+// already holds have been seen again.
+func (u *dropboxUpload) continueSession() error {
+	if hex.EncodeToString(u.hash.Sum(nil)) != u.resume.Hash {
+		return u.restartSession()
+	}
+
+	u.dropSpool()
+	u.session = u.resume.Session
+	u.offset = u.resume.Offset
+	printInfo("Resuming upload to %s at byte %d", u.dest(), u.offset)
+	return nil
+}
+
+// appended reports whether a failed append ending at end was in fact stored,
+// which happens when a retry follows a request whose response got lost.
+func appended(err error, end int64) bool {
+	var serr *statusError
+	if !errors.As(err, &serr) || serr.status != http.StatusConflict {
+		return false
+	}
+
+	var resp struct {
+		Error struct {
+			Tag           string `json:".tag"`
+			CorrectOffset int64  `json:"correct_offset"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(serr.body, &resp) != nil {
+		return false
+	}
+	return resp.Error.Tag == "incorrect_offset" && resp.Error.CorrectOffset == end
+}
+
+// forgetRejected drops the saved state when Dropbox refused the session
+// itself, e.g. because it expired. Other failures leave it to be resumed.
+func (u *dropboxUpload) forgetRejected(err error) {
+	var serr *statusError
+	if errors.As(err, &serr) && serr.status == http.StatusConflict {
+		clearUploadState(u.dest())
+	}
+}
+
+// restartSession forgets an interrupted session that cannot be continued,
+// as the data changed since, and uploads the bytes skipped so far to a new
+// one.
+func (u *dropboxUpload) restartSession() error {
+	clearUploadState(u.dest())
+	printInfo("The data changed since the interrupted upload to %s, starting over", u.dest())
+	u.resume, u.skip = nil, 0
+	u.hash = sha256.New()
+
+	spool := u.spool
+	u.spool = nil
+	if spool == nil {
+		return nil
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("dropbox: %w", err)
+	}
+	_, err := io.Copy(u, spool)
+	return err
+}
+
+// abort leaves an unfinished session to be resumed by the next run.
+func (u *dropboxUpload) abort() {
+	u.dropSpool()
+}
+
+// Close commits the upload session to its final path.
+func (u *dropboxUpload) Close() error {
+	if u.concurrent {
+		if err := u.closeConcurrent(); err != nil {
+			return err
+		}
+	}
+	if u.skip > 0 {
+		// Less data than was uploaded before.
+		if err := u.restartSession(); err != nil {
+			return err
+		}
+	}
+	if u.session == "" {
+		if err := u.flush(); err != nil {
+			return err
+		}
+	}
+
+	arg := map[string]any{
+		"cursor": dropboxCursor{SessionID: u.session, Offset: u.offset},
+		"commit": map[string]any{
+			"path": u.path,
+			"mode": "overwrite",
+			"mute": true,
+		},
+	}
+	if _, err := u.call("upload_session/finish", arg, u.buf); err != nil {
+		u.forgetRejected(err)
+		return err
+	}
+
+	clearUploadState(u.dest())
+	return nil
+}
+
+// closeConcurrent waits for the background uploads and sends the remaining
+// data as the closing append. A concurrent session has to be closed before
+// it can be finished.
+func (u *dropboxUpload) closeConcurrent() error {
+	u.wg.Wait()
+	if err := u.failed(); err != nil {
+		return err
+	}
+	if u.session == "" {
+		if err := u.startConcurrent(); err != nil {
+			return err
+		}
+	}
+
+	arg := map[string]any{
+		"cursor": dropboxCursor{SessionID: u.session, Offset: u.offset},
+		"close":  true,
+	}
+	if _, err := u.call("upload_session/append_v2", arg, u.buf); err != nil && !appended(err, u.offset+int64(len(u.buf))) {
+		return err
+	}
+	u.offset += int64(len(u.buf))
+	u.buf = u.buf[:0]
+	return nil
+}
+
+func (u *dropboxUpload) call(endpoint string, arg any, data []byte) ([]byte, error) {
+	header, err := dropboxArg(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := doAuthorized(u.token, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, "https://content.dropboxapi.com/2/files/"+endpoint, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", header)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dropbox: %w", err)
+	}
+	return body, nil
+}
+
+// dropboxArg encodes the Dropbox-API-Arg header. HTTP headers must be ASCII,
+// so everything else is escaped the way the API expects.
+func dropboxArg(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, r := range string(data) {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		for _, c := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&b, "\\u%04x", c)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var dryRun bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "n", false, "Walk and filter the sources and print what would be archived where, without writing anything")
+}
+
+// dryRunEntry is an entry a dry run would store, as listed by --json.
+type dryRunEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int64  `json:"size,omitempty"`
+	Link string `json:"link,omitempty"`
+}
+
+// planOutputs tells that a dry run would write what to dsts. With --json
+// the outputs go into the result, as stdout is for that alone.
+func planOutputs(what string, dsts []string) {
+	if jsonOutput {
+		result.WouldWrite = append(result.WouldWrite, dsts...)
+		return
+	}
+	fmt.Printf("Would write %s to %s\n", what, strings.Join(dsts, ", "))
+}
+
+// listArchive prints the entries a backup would store instead of writing
+// them, or adds them to the result with --json.
+type listArchive struct{}
+
+func (listArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if jsonOutput {
+		e := dryRunEntry{Name: hdr.Name, Type: "file", Size: hdr.Size}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.Type = "dir"
+		case tar.TypeSymlink:
+			e.Type, e.Link = "symlink", hdr.Linkname
+		case tar.TypeLink:
+			e.Type, e.Link = "hardlink", hdr.Linkname
+		}
+		result.Entries = append(result.Entries, e)
+		return nil
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fmt.Printf("  %s/\n", hdr.Name)
+	case tar.TypeSymlink:
+		fmt.Printf("  %s -> %s\n", hdr.Name, hdr.Linkname)
+	default:
+		fmt.Printf("  %s (%s)\n", hdr.Name, formatSize(hdr.Size))
+	}
+	return nil
+}
+
+func (listArchive) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// envPrefix starts the environment variables bak reads its flags from.
+// BAK_KEEP_GOING=1 is --keep-going, for where flags are awkward to pass,
+// as in containers and CI. Flags given win over the environment, which
+// wins over the config file.
+const envPrefix = "BAK_"
+
+// envAliases are variables named for what they set rather than after
+// their flag.
+var envAliases = map[string]string{
+	"OUTPUT":   "path",
+	"EXCLUDES": "exclude",
+}
+
+// applyEnv sets the flags in fs not given on the command line from the
+// BAK_ variables in the environment. Flags that take several values take
+// a list, separated like PATH.
+func applyEnv(fs *pflag.FlagSet) error {
+	// Sorted, so an alias and its flag's own variable resolve the same
+	// every time.
+	env := os.Environ()
+	sort.Strings(env)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		suffix, ok := strings.CutPrefix(key, envPrefix)
+		if !ok || suffix == "" {
+			continue
+		}
+		name, ok := envAliases[suffix]
+		if !ok {
+			name = strings.ToLower(strings.ReplaceAll(suffix, "_", "-"))
+		}
+
+		f := fs.Lookup(name)
+		if f == nil {
+			if lookupFlag(name) == nil {
+				printWarning("%s: bak has no such setting, ignoring it", key)
+			}
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+		values := []string{value}
+		if _, ok := f.Value.(pflag.SliceValue); ok {
+			values = filepath.SplitList(value)
+		}
+		if err := setValues(f, values); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		flagOrigins[f.Name] = "env " + key
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+)
+
+var errorReport string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&errorReport, "error-report", "", "Write the files that failed or were skipped, with the reason, to this JSON file")
+}
+
+// skippedEntry is an entry the filters left out of the backup.
+type skippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// skippedEntries are only collected for --error-report, as a large tree
+// can skip a lot.
+var skippedEntries []skippedEntry
+
+// entrySkipped counts an entry the filters left out for reason.
+func entrySkipped(path, reason string) {
+	if reason == skipSocket {
+		printWarning("%s is a socket, left out", path)
+	}
+	result.Skipped++
+	if errorReport != "" {
+		skippedEntries = append(skippedEntries, skippedEntry{Path: path, Reason: reason})
+	}
+}
+
+// errorReportFile is what --error-report writes. Failed lists the paths
+// that can be given to --files-from to retry just those.
+type errorReportFile struct {
+	Failed  []fileError    `json:"failed"`
+	Skipped []skippedEntry `json:"skipped"`
+	Errors  []string       `json:"errors"`
+}
+
+// writeErrorReport writes the report for --error-report, if asked for.
+func writeErrorReport() error {
+	if errorReport == "" {
+		return nil
+	}
+
+	report := errorReportFile{Failed: result.FileErrors, Skipped: skippedEntries, Errors: result.Errors}
+	if report.Skipped == nil {
+		report.Skipped = []skippedEntry{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(errorReport, append(data, '\n'), 0o644)
+}
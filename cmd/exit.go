@@ -0,0 +1,104 @@
+package cmd
+
+import "errors"
+
+// Exit codes of bak. Monitoring can tell the kinds of failure apart by
+// them, they are listed in the help as well.
+const (
+	exitOK = 0
+	// exitFatal is any failure without a more specific code.
+	exitFatal = 1
+	// exitUsage means invalid flags or arguments, nothing was done.
+	exitUsage = 2
+	// exitWarnings means the backup completed, but some files were left
+	// out because of problems with them.
+	exitWarnings = 3
+	// exitPartial means some destinations were written and others failed.
+	exitPartial = 4
+	// exitDestination means no destination could be written.
+	exitDestination = 10
+	// exitSource means a source could not be read.
+	exitSource = 11
+	// exitBudget means the sources exceed --max-total-size.
+	exitBudget = 12
+	// exitHook means a pre or post hook from the config failed.
+	exitHook = 13
+	// exitInterrupted means bak was stopped by SIGINT or SIGTERM, like a
+	// shell reports a process killed by SIGINT.
+	exitInterrupted = 130
+)
+
+const exitCodeHelp = `Exit codes:
+  0   success
+  1   failure without a more specific code
+  2   invalid flags or arguments
+  3   completed, but some files were left out because of problems
+  4   some destinations failed, others were written
+  10  no destination could be written
+  11  a source could not be read
+  12  the sources exceed --max-total-size
+  13  a pre or post hook failed, a failed pre hook stops the backup
+  130 interrupted, unfinished outputs were removed`
+
+// exitStatus is the code bak exits with.
+var exitStatus = exitOK
+
+// setExitCode records a failure. Specific codes win over exitFatal, and any
+// failure over exitWarnings, so the most telling code is kept. An
+// interruption wins over everything.
+func setExitCode(code int) {
+	rank := func(c int) int {
+		switch c {
+		case exitOK:
+			return 0
+		case exitWarnings:
+			return 1
+		case exitFatal:
+			return 2
+		case exitInterrupted:
+			return 4
+		}
+		return 3
+	}
+	if rank(code) > rank(exitStatus) {
+		exitStatus = code
+	}
+}
+
+// exitError carries the exit code for an error up to where it is reported.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// reportError prints err and records its exit code. Joined errors are
+// reported one by one.
+func reportError(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			reportError(e)
+		}
+		return
+	}
+
+	var ee *exitError
+	if errors.As(err, &ee) {
+		setExitCode(ee.code)
+	}
+	printError(err)
+}
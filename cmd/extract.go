@@ -0,0 +1,560 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isArchiveFile reports whether path is an archive restore extracts. Its
+// contents tell, or failing that its name. Files kept in the store are
+// checked for first, see inStore.
+func isArchiveFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && (fileFormat(path) != formatUnknown || isArchiveName(path))
+}
+
+// restoreJobs is how many entries are written at once on restore. Writing
+// files waits on the disk more than on the CPU, so without --jobs there are
+// a few even on a single core.
+func restoreJobs() int {
+	if jobs > 0 {
+		return jobs
+	}
+	return max(runtime.NumCPU(), 4)
+}
+
+// extractor writes the entries of an archive below dir on up to
+// restoreJobs goroutines.
+type extractor struct {
+	dir   string
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	errs  []error
+	files atomic.Int64
+	bytes atomic.Int64
+	// skipped counts the entries target leaves out.
+	skipped int
+	// xattrs holds the extended attributes of the entries of a zip
+	// archive.
+	xattrs map[string]map[string]string
+}
+
+// extractArchive restores the entries of the tar or zip archive at path
+// below dir, its format told by its contents. Tar archives may be
+// compressed with gzip or bzip2. Entries of a zip archive
+// are read at once, largest first, those of a tar archive only written at
+// once, as its stream can only be read in order; large ones are written as
+// they are read while small ones are written beside them.
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return withExitCode(exitSource, err)
+	}
+	defer f.Close()
+
+	x := &extractor{dir: dir, sem: make(chan struct{}, restoreJobs())}
+	br := bufio.NewReader(f)
+	if sniffFormat(br) == formatZip {
+		err = x.extractZip(path)
+	} else {
+		err = x.extractTar(br)
+	}
+	x.wg.Wait()
+	if errors.Is(err, errInterrupted) {
+		x.fail(interruptedError(err))
+	} else if err != nil {
+		x.fail(withExitCode(exitSource, fmt.Errorf("%s: %w", path, err)))
+	}
+	reportConflicts()
+	if x.skipped > 0 {
+		printInfo("Skipped %d entries naming the stripped prefix or a directory it is in", x.skipped)
+	}
+	if len(x.errs) > 0 {
+		return errors.Join(x.errs...)
+	}
+	printSuccess("Restored %d files (%s) from %s to %s", x.files.Load(), formatSize(x.bytes.Load()), path, dir)
+	return nil
+}
+
+func (x *extractor) fail(err error) {
+	x.mu.Lock()
+	x.errs = append(x.errs, err)
+	x.mu.Unlock()
+}
+
+// target returns where the entry name is restored, "" for entries that
+// are skipped. Names that would end up outside dir are refused, as are
+// those below a symlink in dir, which could point anywhere.
+func (x *extractor) target(name string) (string, error) {
+	if p := portableName(name, runtime.GOOS == "windows"); p != name {
+		printWarning("%s: restoring as %s", name, p)
+	}
+	local := localName(name)
+	if local == "" {
+		return "", nil
+	}
+	if _, ok := absoluteEntry(local); ok {
+		return longPath(filepath.Clean(local)), nil
+	}
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%s: refusing to restore outside of %s", local, x.dir)
+	}
+	if err := x.checkParents(name); err != nil {
+		return "", err
+	}
+	return longPath(filepath.Join(x.dir, local)), nil
+}
+
+// localName returns the path the entry name is restored at, relative to
+// the restore directory, or absolute when restoresAbsolute. It is "" for
+// the stripped prefix and the directories it is in, which would be the
+// restore directory itself.
+func localName(name string) string {
+	name = portableName(name, runtime.GOOS == "windows")
+	if len(pathMaps) > 0 {
+		name = mapName(name)
+	}
+	if transformingNames() {
+		var ok bool
+		if name, ok = transformName(strings.TrimSuffix(name, "/")); !ok || name == "" {
+			return ""
+		}
+	}
+	name = filepath.FromSlash(name)
+	if rel, ok := absoluteEntry(name); ok && !restoresAbsolute(name) {
+		// Like tar, the leading / is dropped otherwise.
+		name = rel
+	}
+	return name
+}
+
+// checkParents refuses the entry name when one of the directories it is
+// restored in below x.dir is a symlink. Checked again right before links
+// are made, as symlinks from the archive are made last of all.
+func (x *extractor) checkParents(name string) error {
+	local := localName(name)
+	if _, ok := absoluteEntry(local); ok {
+		return nil
+	}
+	dir := x.dir
+	for _, elem := range strings.Split(filepath.Dir(local), string(filepath.Separator)) {
+		if elem == "." {
+			continue
+		}
+		dir = filepath.Join(dir, elem)
+		fi, err := os.Lstat(longPath(dir))
+		if err != nil {
+			return nil
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s: refusing to restore through the symlink %s", name, dir)
+		}
+	}
+	return nil
+}
+
+// makeEntryDir makes the directory for a directory entry, which must not
+// be a symlink already.
+func makeEntryDir(target string) error {
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%s: refusing to restore through the symlink", target)
+	}
+	return makeDir(target)
+}
+
+// isDir reports whether target is a directory and not a symlink to one.
+func isDir(target string) bool {
+	fi, err := os.Lstat(target)
+	return err == nil && fi.IsDir()
+}
+
+// symlink is a symlink entry, made once everything else is written so no
+// entry is written through it.
+type symlink struct {
+	name, target, link string
+	owner              *owner
+}
+
+// makeSymlinks makes the symlinks, in the order of the archive.
+func (x *extractor) makeSymlinks(links []symlink) {
+	for _, l := range links {
+		if err := x.checkParents(l.name); err != nil {
+			x.fail(withExitCode(exitSource, err))
+			continue
+		}
+		os.Remove(l.target)
+		if err := os.Symlink(l.link, l.target); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+		} else if err := l.owner.apply(l.target); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+		}
+	}
+}
+
+// prepare makes the directory for the entry at target, last modified at
+// mtime, and returns where it is written, after --on-conflict. It returns
+// "" for an entry that is left out, after reporting why if it failed.
+func (x *extractor) prepare(target string, mtime time.Time) string {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		x.fail(withExitCode(exitDestination, err))
+		return ""
+	}
+	to, err := resolveConflict(target, mtime)
+	if err != nil {
+		x.fail(withExitCode(exitDestination, fmt.Errorf("%s: %w", target, err)))
+		return ""
+	}
+	return to
+}
+
+// start runs write on a goroutine of its own once fewer than restoreJobs
+// are running.
+func (x *extractor) start(write func() error) {
+	x.sem <- struct{}{}
+	x.wg.Add(1)
+	go func() {
+		defer func() {
+			<-x.sem
+			x.wg.Done()
+		}()
+		if err := write(); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+		}
+	}()
+}
+
+// writeFile restores a regular file from r. Runs of zeros become holes, so
+// sparse files come back sparse.
+func (x *extractor) writeFile(target string, r io.Reader, m metadata) error {
+	out, err := createLocal(target)
+	if err != nil {
+		return err
+	}
+	sw := &sparseWriter{f: out.File}
+	n, err := copyBlocks(sw, interruptReader{r})
+	if err == nil {
+		err = sw.finish()
+	}
+	if err != nil {
+		out.abort()
+		return fmt.Errorf("%s: %w", target, interruptedError(err))
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	x.files.Add(1)
+	x.bytes.Add(n)
+	return setMetadata(target, m)
+}
+
+// tarMetadata returns the metadata of the entry hdr describes.
+func tarMetadata(hdr *tar.Header) metadata {
+	btime, flags := headerMacMetadata(hdr)
+	return metadata{
+		owner:     headerOwner(hdr),
+		mode:      restoreMode(hdr.FileInfo().Mode()),
+		mtime:     hdr.ModTime,
+		atime:     hdr.ModTime,
+		xattrs:    headerXattrs(hdr),
+		birthtime: btime,
+		flags:     flags,
+	}
+}
+
+// zipMetadata returns the metadata of the zip entry f.
+func (x *extractor) zipMetadata(f *zip.File) metadata {
+	return metadata{
+		owner:  zipEntryOwner(f),
+		mode:   restoreMode(f.Mode()),
+		mtime:  f.Modified,
+		atime:  f.Modified,
+		xattrs: x.xattrs[strings.TrimSuffix(f.Name, "/")],
+	}
+}
+
+func (x *extractor) extractZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The files are read until the last one is written.
+		x.wg.Wait()
+		zr.Close()
+	}()
+
+	type file struct {
+		f      *zip.File
+		target string
+	}
+	for _, f := range zr.File {
+		if f.Name == zipXattrsName {
+			if x.xattrs, err = readZipEntryXattrs(f); err != nil {
+				printWarning("%s: extended attributes: %v", path, err)
+			}
+		}
+	}
+
+	var dirs []*zip.File
+	var files []file
+	var links []symlink
+	for _, f := range zr.File {
+		if interrupted.Load() {
+			return errInterrupted
+		}
+		if f.Name == zipXattrsName {
+			continue
+		}
+		target, err := x.target(f.Name)
+		if err != nil {
+			x.fail(withExitCode(exitSource, err))
+			continue
+		}
+		if target == "" {
+			x.skipped++
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := makeEntryDir(target); err != nil {
+				x.fail(withExitCode(exitDestination, err))
+			}
+			dirs = append(dirs, f)
+		case mode&fs.ModeSymlink != 0:
+			if target = x.prepare(target, f.Modified); target == "" {
+				continue
+			}
+			link, err := readZipSymlink(f)
+			if err != nil {
+				x.fail(withExitCode(exitSource, fmt.Errorf("%s: %w", f.Name, err)))
+				continue
+			}
+			links = append(links, symlink{f.Name, target, link, zipEntryOwner(f)})
+		case mode.IsRegular():
+			if target = x.prepare(target, f.Modified); target == "" {
+				continue
+			}
+			files = append(files, file{f, target})
+		default:
+			printWarning("%s: not restoring %s", f.Name, mode.Type())
+		}
+	}
+
+	// The largest files start first and the small ones fill in around
+	// them, so no single large file is left running at the end.
+	slices.SortStableFunc(files, func(a, b file) int {
+		return cmp.Compare(b.f.UncompressedSize64, a.f.UncompressedSize64)
+	})
+	for _, e := range files {
+		if interrupted.Load() {
+			return errInterrupted
+		}
+		x.start(func() error {
+			rc, err := e.f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", e.f.Name, err)
+			}
+			defer rc.Close()
+			return x.writeFile(e.target, rc, x.zipMetadata(e.f))
+		})
+	}
+	x.wg.Wait()
+	x.makeSymlinks(links)
+	x.setDirMetadata(dirs)
+	return nil
+}
+
+// readZipSymlink returns the target of the symlink f, which a zip archive
+// stores as its contents.
+func readZipSymlink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	link, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget))
+	return string(link), err
+}
+
+// readZipEntryXattrs reads the extended attributes kept in the entry f.
+func readZipEntryXattrs(f *zip.File) (map[string]map[string]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readZipXattrs(rc)
+}
+
+// zipEntryOwner returns the owner f is restored with, nil when owners are
+// not restored or f has none, and --chown does not set one.
+func zipEntryOwner(f *zip.File) *owner {
+	if !restoringOwners() {
+		return overrideOwner(nil)
+	}
+	return overrideOwner(zipOwner(f.Extra))
+}
+
+// setDirMetadata sets the modes and times of directories once the files in
+// them are written, which changes their times.
+func (x *extractor) setDirMetadata(dirs []*zip.File) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, err := x.target(dirs[i].Name)
+		if err != nil || !isDir(target) {
+			// Replaced by a symlink since.
+			continue
+		}
+		if err := setMetadata(target, x.zipMetadata(dirs[i])); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+		}
+	}
+}
+
+func (x *extractor) extractTar(br *bufio.Reader) error {
+	r, done, err := tarStream(br)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	type dir struct {
+		target string
+		hdr    *tar.Header
+	}
+	var dirs []dir
+	// Hard links are made once the files they point to are written.
+	type link struct {
+		name, target, to, toName string
+	}
+	var links []link
+	var symlinks []symlink
+	tr := tar.NewReader(r)
+	for {
+		if interrupted.Load() {
+			return errInterrupted
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target, err := x.target(hdr.Name)
+		if err != nil {
+			x.fail(withExitCode(exitSource, err))
+			continue
+		}
+		if target == "" {
+			x.skipped++
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := makeEntryDir(target); err != nil {
+				x.fail(withExitCode(exitDestination, err))
+			}
+			dirs = append(dirs, dir{target, hdr})
+		case tar.TypeSymlink:
+			if target = x.prepare(target, hdr.ModTime); target == "" {
+				continue
+			}
+			symlinks = append(symlinks, symlink{hdr.Name, target, hdr.Linkname, headerOwner(hdr)})
+		case tar.TypeLink:
+			to, err := x.target(hdr.Linkname)
+			if err != nil {
+				x.fail(withExitCode(exitSource, err))
+				continue
+			}
+			if to == "" {
+				// Links to a file that is skipped as well.
+				x.skipped++
+				continue
+			}
+			if target = x.prepare(target, hdr.ModTime); target == "" {
+				continue
+			}
+			links = append(links, link{hdr.Name, target, to, hdr.Linkname})
+		case tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+			if target = x.prepare(target, hdr.ModTime); target == "" {
+				continue
+			}
+			if err := headerNode(target, hdr); err != nil {
+				x.fail(withExitCode(exitDestination, err))
+			} else if err := setMetadata(target, tarMetadata(hdr)); err != nil {
+				x.fail(withExitCode(exitDestination, err))
+			} else {
+				x.files.Add(1)
+			}
+		case tar.TypeReg:
+			if target = x.prepare(target, hdr.ModTime); target == "" {
+				continue
+			}
+			if hdr.Size > prefetchMax {
+				// Large files are written as they are read.
+				if err := x.writeFile(target, tr, tarMetadata(hdr)); err != nil {
+					x.fail(withExitCode(exitDestination, err))
+				}
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			m := tarMetadata(hdr)
+			x.start(func() error {
+				return x.writeFile(target, bytes.NewReader(data), m)
+			})
+		default:
+			printWarning("%s: not restoring entries of type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	x.wg.Wait()
+	for _, l := range links {
+		err := x.checkParents(l.name)
+		if err == nil {
+			err = x.checkParents(l.toName)
+		}
+		if err != nil {
+			x.fail(withExitCode(exitSource, err))
+			continue
+		}
+		os.Remove(l.target)
+		if err := os.Link(l.to, l.target); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+			continue
+		}
+		x.files.Add(1)
+	}
+	x.makeSymlinks(symlinks)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if !isDir(d.target) {
+			// Replaced by a symlink since.
+			continue
+		}
+		if err := setMetadata(d.target, tarMetadata(d.hdr)); err != nil {
+			x.fail(withExitCode(exitDestination, err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTar writes an archive of hdrs to path, each regular file with the
+// contents "x".
+func writeTar(t *testing.T, path string, hdrs ...*tar.Header) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = 1
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte("x"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExtractThroughSymlink makes sure entries are never written through
+// a symlink the archive itself makes.
+func TestExtractThroughSymlink(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hdrs func(victim string) []*tar.Header
+	}{
+		{"file", func(victim string) []*tar.Header {
+			return []*tar.Header{
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: victim},
+				{Name: "a/pwned", Typeflag: tar.TypeReg},
+			}
+		}},
+		{"hard link", func(victim string) []*tar.Header {
+			return []*tar.Header{
+				{Name: "f", Typeflag: tar.TypeReg},
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: victim},
+				{Name: "a/pwned", Typeflag: tar.TypeLink, Linkname: "f"},
+			}
+		}},
+		{"symlink", func(victim string) []*tar.Header {
+			return []*tar.Header{
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: victim},
+				{Name: "a/pwned", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			victim := filepath.Join(tmp, "victim")
+			dir := filepath.Join(tmp, "restore")
+			for _, d := range []string{victim, dir} {
+				if err := os.Mkdir(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archive := filepath.Join(tmp, "a.tar")
+			writeTar(t, archive, tc.hdrs(victim)...)
+
+			if err := extractArchive(archive, dir); err == nil {
+				t.Error("extractArchive succeeded, want an error for a or a/pwned")
+			}
+			if _, err := os.Lstat(filepath.Join(victim, "pwned")); err == nil {
+				t.Error("a/pwned was written through the symlink a")
+			}
+		})
+	}
+}
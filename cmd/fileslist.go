@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	filesFrom  []string
+	filesFrom0 []string
+)
+
+// keepPaths stores sources under the path they were given with instead of
+// their base name. It is set when sources come from a file list, where
+// several files may share a name.
+var keepPaths bool
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&filesFrom, "files-from", nil, "Read the sources from a file, one per line, or from stdin for -, and store them under their relative paths (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&filesFrom0, "files-from0", nil, "Like --files-from, but the names are separated by NUL bytes, as printed by find -print0 (repeatable)")
+}
+
+func hasFileLists() bool {
+	return len(filesFrom) > 0 || len(filesFrom0) > 0 || fromManifest != ""
+}
+
+// readFileLists returns the sources named in the --files-from and
+// --files-from0 lists and in --from-manifest.
+func readFileLists() ([]string, error) {
+	var paths []string
+	if fromManifest != "" {
+		list, err := readManifest(fromManifest)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, list...)
+	}
+	for _, name := range filesFrom {
+		list, err := readFileList(name, '\n')
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, list...)
+	}
+	for _, name := range filesFrom0 {
+		list, err := readFileList(name, 0)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, list...)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("the file lists are empty")
+	}
+	return paths, nil
+}
+
+// readFileList reads the list in the file name, or on stdin for "-".
+func readFileList(name string, sep byte) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, p := range bytes.Split(data, []byte{sep}) {
+		s := string(p)
+		if sep == '\n' {
+			s = strings.TrimSuffix(s, "\r")
+		}
+		if s != "" {
+			paths = append(paths, s)
+		}
+	}
+	return paths, nil
+}
+
+// listedDir returns the directory a listed source is stored in. Absolute
+// paths and leading ".." are dropped so entries stay inside the archive.
+func listedDir(path string) string {
+	dir := filepath.Dir(filepath.Clean(path))
+	dir = strings.TrimPrefix(dir, filepath.VolumeName(dir))
+	dir = strings.TrimLeft(filepath.ToSlash(dir), "/")
+	for dir == ".." || strings.HasPrefix(dir, "../") {
+		dir = strings.TrimPrefix(strings.TrimPrefix(dir, ".."), "/")
+	}
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return filepath.FromSlash(dir)
+}
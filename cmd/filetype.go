@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	fileTypes  []string
+	sniffTypes bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&fileTypes, "type", nil, "Only archive files of these kinds: "+strings.Join(fileTypeNames(), ", "))
+	rootCmd.PersistentFlags().BoolVar(&sniffTypes, "sniff-types", false, "With --type, also look at the content of files whose extension is unknown")
+}
+
+// fileTypeExtensions maps the kinds accepted by --type to file extensions.
+var fileTypeExtensions = map[string][]string{
+	"image":    {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".webp", ".heic", ".heif", ".svg", ".raw", ".cr2", ".nef", ".arw", ".dng"},
+	"video":    {".mp4", ".m4v", ".mov", ".avi", ".mkv", ".webm", ".wmv", ".flv", ".mpg", ".mpeg", ".3gp"},
+	"audio":    {".mp3", ".wav", ".flac", ".aac", ".ogg", ".oga", ".m4a", ".wma", ".opus", ".aiff"},
+	"document": {".pdf", ".doc", ".docx", ".odt", ".rtf", ".txt", ".md", ".xls", ".xlsx", ".ods", ".csv", ".ppt", ".pptx", ".odp", ".epub", ".pages", ".numbers", ".key"},
+	"archive":  {".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar"},
+	"code":     {".go", ".c", ".h", ".cpp", ".hpp", ".cs", ".java", ".kt", ".py", ".rb", ".rs", ".js", ".ts", ".jsx", ".tsx", ".php", ".sh", ".swift", ".html", ".css", ".sql"},
+}
+
+func fileTypeNames() []string {
+	names := make([]string, 0, len(fileTypeExtensions))
+	for name := range fileTypeExtensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkFileTypes() error {
+	for _, t := range fileTypes {
+		if _, ok := fileTypeExtensions[t]; !ok {
+			return fmt.Errorf("--type: unknown type %q, available are %s", t, strings.Join(fileTypeNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// hasFileType reports whether the entry is of one of the kinds asked for,
+// judged by its extension and, with --sniff-types, by its content.
+func hasFileType(e walkEntry) bool {
+	ext := strings.ToLower(path.Ext(e.rel))
+	for _, t := range fileTypes {
+		for _, x := range fileTypeExtensions[t] {
+			if ext == x {
+				return true
+			}
+		}
+	}
+
+	if !sniffTypes || e.file == "" {
+		return false
+	}
+	kind := sniffFileType(e.file)
+	for _, t := range fileTypes {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffFileType guesses the kind of a file from its first bytes.
+func sniffFileType(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	mime := http.DetectContentType(buf[:n])
+
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return "image"
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	case strings.HasPrefix(mime, "audio/"), mime == "application/ogg":
+		return "audio"
+	case mime == "application/pdf", mime == "text/rtf":
+		return "document"
+	case mime == "application/zip", mime == "application/x-gzip", mime == "application/x-rar-compressed":
+		return "archive"
+	}
+	return ""
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	excludes     []string
+	excludeFiles []string
+	presets      []string
+	includes     []string
+	excludeRegex []string
+	includeRegex []string
+	excludeCache bool
+	markerFiles  []string
+	maxDepth     int
+	maxFileSize  string
+	minFileSize  string
+	newerThan    string
+	olderThan    string
+)
+
+// Modification time bounds, zero when unset.
+var (
+	newerCutoff time.Time
+	olderCutoff time.Time
+)
+
+// Compiled --exclude-regex and --include-regex patterns.
+var (
+	excludeREs []*regexp.Regexp
+	includeREs []*regexp.Regexp
+)
+
+// Parsed size limits, -1 when unset.
+var (
+	maxFileBytes int64 = -1
+	minFileBytes int64 = -1
+)
+
+// sizeSkipped counts the files left out by the size limits.
+var sizeSkipped struct {
+	files int
+	bytes int64
+}
+
+// cacheDirSignature starts every valid CACHEDIR.TAG file, see
+// https://bford.info/cachedir/.
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// excludePresets are named sets of exclude patterns for --preset.
+var excludePresets = map[string][]string{
+	"dev": {
+		".git", "node_modules", ".venv", "venv", "__pycache__", "*.pyc",
+		".pytest_cache", ".mypy_cache", ".tox", "target", ".gradle",
+	},
+	"os-junk": {
+		".DS_Store", "._*", ".Spotlight-V100", ".Trashes", ".fseventsd",
+		"Thumbs.db", "ehthumbs.db", "desktop.ini", "$RECYCLE.BIN",
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&excludes, "exclude", nil, "Skip files and directories matching a glob, e.g. 'node_modules' or '*.o' (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&excludeFiles, "exclude-from", nil, "Read exclude patterns from a file, one per line, # starts a comment (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&presets, "preset", nil, "Exclude a built-in set of patterns: dev, os-junk (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&excludeCache, "exclude-caches", false, "Skip directories marked as caches by a CACHEDIR.TAG file")
+	rootCmd.PersistentFlags().StringArrayVar(&markerFiles, "exclude-if-present", nil, "Skip directories that contain a file of this name, e.g. .nobackup (repeatable)")
+	rootCmd.PersistentFlags().IntVar(&maxDepth, "max-depth", 0, "Only descend this many directory levels into a source, 0 for no limit")
+	rootCmd.PersistentFlags().StringVar(&maxFileSize, "max-file-size", "", "Skip files larger than this, e.g. 100M")
+	rootCmd.PersistentFlags().StringVar(&minFileSize, "min-file-size", "", "Skip files smaller than this, e.g. 1K")
+	rootCmd.PersistentFlags().StringVar(&newerThan, "newer-than", "", "Only archive files modified within this age or since this date, e.g. 7d, 12h or 2024-05-01")
+	rootCmd.PersistentFlags().StringVar(&olderThan, "older-than", "", "Only archive files modified before this age or date, e.g. 30d")
+	rootCmd.PersistentFlags().StringArrayVar(&includes, "include", nil, "Only archive files matching a glob, or lying in a directory matching it (repeatable, excludes take precedence)")
+	rootCmd.PersistentFlags().StringArrayVar(&excludeRegex, "exclude-regex", nil, "Skip paths matching a regular expression, directories are matched with a trailing slash (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&includeRegex, "include-regex", nil, "Only archive paths matching a regular expression, like --include (repeatable)")
+}
+
+// selection is what happens to an entry found while walking a source.
+type selection int
+
+const (
+	// selectKeep archives the entry and walks into it if it is a directory.
+	selectKeep selection = iota
+	// selectDescend walks into a directory without storing the directory
+	// itself, so only the files below it that are kept show up.
+	selectDescend
+	// selectSkip leaves the entry and everything below it out.
+	selectSkip
+)
+
+// setFilters loads and checks the filter flags before anything is written.
+func setFilters() error {
+	for _, name := range excludeFiles {
+		patterns, err := readPatternFile(name)
+		if err != nil {
+			return fmt.Errorf("--exclude-from: %w", err)
+		}
+		excludes = append(excludes, patterns...)
+	}
+	for _, name := range presets {
+		patterns, ok := excludePresets[name]
+		if !ok {
+			return fmt.Errorf("--preset: unknown preset %q, available are dev and os-junk", name)
+		}
+		excludes = append(excludes, patterns...)
+	}
+
+	if maxFileSize != "" {
+		n, err := parseSize(maxFileSize)
+		if err != nil {
+			return fmt.Errorf("--max-file-size: %w", err)
+		}
+		maxFileBytes = n
+	}
+	if minFileSize != "" {
+		n, err := parseSize(minFileSize)
+		if err != nil {
+			return fmt.Errorf("--min-file-size: %w", err)
+		}
+		minFileBytes = n
+	}
+
+	now := time.Now()
+	if newerThan != "" {
+		t, err := parseTimeBound(newerThan, now)
+		if err != nil {
+			return fmt.Errorf("--newer-than: %w", err)
+		}
+		newerCutoff = t
+	}
+	if olderThan != "" {
+		t, err := parseTimeBound(olderThan, now)
+		if err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+		olderCutoff = t
+	}
+
+	for _, p := range excludes {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("--exclude %q: %w", p, err)
+		}
+	}
+	for _, p := range includes {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("--include %q: %w", p, err)
+		}
+	}
+	for _, p := range excludeRegex {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("--exclude-regex: %w", err)
+		}
+		excludeREs = append(excludeREs, re)
+	}
+	for _, p := range includeRegex {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("--include-regex: %w", err)
+		}
+		includeREs = append(includeREs, re)
+	}
+	return checkFileTypes()
+}
+
+// readPatternFile reads one pattern per line, skipping blank lines and
+// comments.
+func readPatternFile(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// matchGlob matches pattern against rel, a slash-separated path inside the
+// archive. A pattern without a slash applies to the name of every file and
+// directory, one with a slash to the whole path.
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if strings.Contains(pattern, "/") {
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), rel)
+		return ok
+	}
+	ok, _ := path.Match(pattern, path.Base(rel))
+	return ok
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRegex matches rel against regular expressions. Directories get a
+// trailing slash, so a pattern like "/tmp-[0-9]+/$" only hits directories.
+func matchRegex(res []*regexp.Regexp, rel string, isDir bool) bool {
+	if isDir {
+		rel += "/"
+	}
+	for _, re := range res {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCacheDir reports whether dir holds a CACHEDIR.TAG with the standard
+// signature.
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, "CACHEDIR.TAG"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(cacheDirSignature))
+	_, err = io.ReadFull(f, buf)
+	return err == nil && string(buf) == cacheDirSignature
+}
+
+// hasMarkerFile reports whether dir contains one of the --exclude-if-present
+// files.
+func hasMarkerFile(dir string) bool {
+	for _, name := range markerFiles {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func outsideSizeLimits(size int64) bool {
+	return maxFileBytes >= 0 && size > maxFileBytes || minFileBytes >= 0 && size < minFileBytes
+}
+
+func outsideTimeLimits(mtime time.Time) bool {
+	return !newerCutoff.IsZero() && mtime.Before(newerCutoff) || !olderCutoff.IsZero() && !mtime.Before(olderCutoff)
+}
+
+// parseTimeBound turns an age like "7d", "2w" or "90m" into the time that
+// long before now, or parses an absolute date.
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid age %q", s)
+		}
+		return now.Add(-time.Duration(n * float64(unit))), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid age or date %q", s)
+	}
+	return now.Add(-d), nil
+}
+
+// reportSkipped tells about files the filters left out for reasons that
+// are easy to overlook.
+func reportSkipped() {
+	if sizeSkipped.files > 0 {
+		printInfo("Skipped %d files (%s) outside the size limits", sizeSkipped.files, formatSize(sizeSkipped.bytes))
+	}
+}
+
+// walkEntry is an entry found while walking a source.
+type walkEntry struct {
+	// rel is the slash-separated path inside the archive.
+	rel string
+	// file is the path on disk, empty for remote sources.
+	file string
+	// depth is 1 for the entries directly inside the source.
+	depth int
+	info  fs.FileInfo
+}
+
+// selectEntry decides about a walked entry. ign holds the ignore files of
+// the source and is nil for remote ones.
+//
+// Excludes and ignore files always win. With includes, glob or regex, a
+// file is kept if it or one of its parent directories matches one. With
+// --type, only files of those kinds are kept, and with --interactive only
+// the files picked. Directories that are not kept themselves are still
+// walked through.
+func selectEntry(e walkEntry, ign *ignoreMatcher) selection {
+	sel, _ := classifyEntry(e, ign)
+	return sel
+}
+
+// classifyEntry is selectEntry, but also tells why an entry is skipped.
+func classifyEntry(e walkEntry, ign *ignoreMatcher) (selection, string) {
+	if symlinkMode == symlinksSkip && isSymlink(e.info) {
+		return selectSkip, "symlink"
+	}
+	if reason := skipSpecial(e.info); reason != "" {
+		return selectSkip, reason
+	}
+	if maxDepth > 0 && e.depth > maxDepth {
+		return selectSkip, "deeper than --max-depth"
+	}
+	if matchAny(excludes, e.rel) || matchRegex(excludeREs, e.rel, e.info.IsDir()) {
+		return selectSkip, "excluded"
+	}
+	if ign != nil && ign.ignored(e.file, e.info.IsDir()) {
+		return selectSkip, "ignored"
+	}
+	if honorNodump && e.file != "" && hasNodump(e.file, e.info) {
+		return selectSkip, "nodump flag"
+	}
+	if excludeCache && e.file != "" && e.info.IsDir() && isCacheDir(e.file) {
+		return selectSkip, "cache directory"
+	}
+	if e.file != "" && e.info.IsDir() && hasMarkerFile(e.file) {
+		return selectSkip, "marker file present"
+	}
+	if e.info.Mode().IsRegular() && outsideSizeLimits(e.info.Size()) {
+		sizeSkipped.files++
+		sizeSkipped.bytes += e.info.Size()
+		return selectSkip, "outside the size limits"
+	}
+	if e.info.Mode().IsRegular() && outsideTimeLimits(e.info.ModTime()) {
+		return selectSkip, "outside the time limits"
+	}
+
+	filtered := len(includes) > 0 || len(includeREs) > 0
+	if e.info.IsDir() {
+		if len(fileTypes) > 0 || picked != nil || filtered && !included(e.rel, true) {
+			return selectDescend, ""
+		}
+		return selectKeep, ""
+	}
+	if filtered && !included(e.rel, false) {
+		return selectSkip, "not included"
+	}
+	if len(fileTypes) > 0 && !hasFileType(e) {
+		return selectSkip, "not of the --type asked for"
+	}
+	if picked != nil && e.file != "" && !picked[e.file] {
+		return selectSkip, "not picked"
+	}
+	return selectKeep, ""
+}
+
+// included reports whether rel or one of its parent directories matches an
+// include pattern.
+func included(rel string, isDir bool) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchAny(includes, p) || matchRegex(includeREs, p, isDir || p != rel) {
+			return true
+		}
+	}
+	return false
+}
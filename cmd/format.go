@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+)
+
+// archiveFormat is what the first bytes of a file say it holds, whatever
+// its name.
+type archiveFormat int
+
+const (
+	formatUnknown archiveFormat = iota
+	formatTar
+	formatGzip
+	formatBzip2
+	formatZip
+	formatZstd
+	formatXz
+	formatEncrypted
+)
+
+// formatMagic are the bytes each format starts with.
+var formatMagic = []struct {
+	format archiveFormat
+	magic  string
+}{
+	{formatZip, "PK\x03\x04"},
+	// An empty zip archive is only its end record.
+	{formatZip, "PK\x05\x06"},
+	{formatGzip, "\x1f\x8b"},
+	{formatBzip2, "BZh"},
+	{formatZstd, "\x28\xb5\x2f\xfd"},
+	{formatXz, "\xfd7zXZ\x00"},
+	{formatEncrypted, "age-encryption.org/"},
+	{formatEncrypted, "-----BEGIN AGE ENCRYPTED FILE-----"},
+	{formatEncrypted, "Salted__"},
+}
+
+// sniffFormat tells the format of the stream br from its first bytes,
+// without consuming them.
+func sniffFormat(br *bufio.Reader) archiveFormat {
+	head, _ := br.Peek(512)
+	for _, m := range formatMagic {
+		if bytes.HasPrefix(head, []byte(m.magic)) {
+			return m.format
+		}
+	}
+	// The ustar magic of POSIX and GNU tar headers.
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return formatTar
+	}
+	return formatUnknown
+}
+
+// fileFormat returns the format of the file at path.
+func fileFormat(path string) archiveFormat {
+	f, err := os.Open(path)
+	if err != nil {
+		return formatUnknown
+	}
+	defer f.Close()
+	return sniffFormat(bufio.NewReader(f))
+}
+
+// tarStream returns the tar stream in br, decompressed. Anything not known
+// to be something else is read as tar, as old tar headers have no magic.
+func tarStream(br *bufio.Reader) (io.Reader, func(), error) {
+	switch sniffFormat(br) {
+	case formatGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case formatBzip2:
+		return bzip2.NewReader(br), func() {}, nil
+	case formatZstd:
+		return nil, nil, errors.New("zstd compressed archives are not supported, decompress it first")
+	case formatXz:
+		return nil, nil, errors.New("xz compressed archives are not supported, decompress it first")
+	case formatEncrypted:
+		return nil, nil, errors.New("the archive is encrypted, decrypt it first")
+	}
+	return br, func() {}, nil
+}
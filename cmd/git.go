@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+var (
+	gitTracked   bool
+	gitUntracked bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&gitTracked, "git-tracked", false, "Only archive the files git tracks in directory sources, including staged ones")
+	rootCmd.PersistentFlags().BoolVar(&gitUntracked, "git-untracked", false, "With --git-tracked, also archive untracked files that are not ignored")
+}
+
+// gitFiles is the set of files git reports for a directory, with paths
+// relative to it.
+type gitFiles struct {
+	files map[string]bool
+	// dirs holds every directory that has one of the files below it.
+	dirs map[string]bool
+}
+
+func listGitFiles(dir string) (*gitFiles, error) {
+	args := []string{"-C", dir, "ls-files", "-z", "--cached"}
+	if gitUntracked {
+		args = append(args, "--others", "--exclude-standard")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("--git-tracked: %s: %s", dir, msg)
+		}
+		return nil, fmt.Errorf("--git-tracked: %s: %w", dir, err)
+	}
+
+	g := &gitFiles{files: map[string]bool{}, dirs: map[string]bool{}}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		g.files[name] = true
+		for d := path.Dir(name); d != "." && !g.dirs[d]; d = path.Dir(d) {
+			g.dirs[d] = true
+		}
+	}
+	return g, nil
+}
+
+func (g *gitFiles) contains(rel string, isDir bool) bool {
+	if isDir {
+		return g.dirs[rel]
+	}
+	return g.files[rel]
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sett17/bak/bakpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// agentServer serves the gRPC API of bak serve --grpc. Its jobs are those
+// of the REST API, both can follow the other's.
+type agentServer struct {
+	bakpb.UnimplementedAgentServer
+	jobs *jobManager
+}
+
+// serveGRPC serves the gRPC API on l until it fails.
+func serveGRPC(l net.Listener, jobs *jobManager) error {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			if err := jobs.authorizeRPC(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := jobs.authorizeRPC(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	bakpb.RegisterAgentServer(s, &agentServer{jobs: jobs})
+	return s.Serve(l)
+}
+
+// authorizeRPC checks the bearer token of a call, like authorize does for
+// the REST API.
+func (m *jobManager) authorizeRPC(ctx context.Context) error {
+	if m.token == "" {
+		return nil
+	}
+	md, _ := grpcmd.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+}
+
+func (s *agentServer) StartBackup(ctx context.Context, req *bakpb.StartBackupRequest) (*bakpb.Job, error) {
+	args, err := backupArgs(backupRequest{
+		Sources: req.Sources,
+		Paths:   req.Paths,
+		Zip:     req.Zip,
+		Flags:   req.Flags,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return jobProto(s.jobs.status(s.jobs.start(args, req.Paths))), nil
+}
+
+func (s *agentServer) Restore(ctx context.Context, req *bakpb.RestoreRequest) (*bakpb.Job, error) {
+	args, err := restoreArgs(s.jobs.dir, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return jobProto(s.jobs.status(s.jobs.start(args, nil))), nil
+}
+
+// restoreArgs returns the arguments of the bak process that runs the
+// restore req asks for in dir, or an error for a request that is not
+// allowed. The archive must be one of the backups in dir, and the entries
+// stay below it.
+func restoreArgs(dir string, req *bakpb.RestoreRequest) ([]string, error) {
+	p, ok := backupPath(dir, req.Archive)
+	if !ok || !isArchiveName(req.Archive) {
+		return nil, fmt.Errorf("archive %q is not a backup in the served directory", req.Archive)
+	}
+	if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("archive %q is not a backup in the served directory", req.Archive)
+	}
+
+	if !filepath.IsLocal(req.To) {
+		return nil, fmt.Errorf("to %q is not in the served directory", req.To)
+	}
+	// Nor may a symlink lead out of it.
+	to := dir
+	for _, elem := range strings.Split(filepath.Clean(req.To), string(filepath.Separator)) {
+		to = filepath.Join(to, elem)
+		fi, err := os.Lstat(to)
+		if err != nil {
+			break
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return nil, fmt.Errorf("to %q goes through the symlink %s", req.To, to)
+		}
+	}
+
+	conflict := req.OnConflict
+	switch conflict {
+	case "":
+		// There is nobody to ask.
+		conflict = conflictSkip
+	case conflictSkip, conflictOverwrite, conflictKeepNewer, conflictRename:
+	default:
+		return nil, fmt.Errorf("unknown on_conflict %q, use skip, overwrite, keep-newer or rename", conflict)
+	}
+	return []string{"restore", "--no-config", "--on-conflict=" + conflict, "--to=" + req.To, "--", req.Archive}, nil
+}
+
+func (s *agentServer) GetJob(ctx context.Context, req *bakpb.GetJobRequest) (*bakpb.Job, error) {
+	j := s.jobs.find(req.Id)
+	if j == nil {
+		return nil, status.Error(codes.NotFound, "no such job")
+	}
+	return jobProto(s.jobs.status(j)), nil
+}
+
+func (s *agentServer) ListJobs(ctx context.Context, req *bakpb.ListJobsRequest) (*bakpb.ListJobsResponse, error) {
+	s.jobs.mu.Lock()
+	jobs := append([]*job{}, s.jobs.jobs...)
+	s.jobs.mu.Unlock()
+
+	res := &bakpb.ListJobsResponse{}
+	for _, j := range jobs {
+		res.Jobs = append(res.Jobs, jobProto(s.jobs.status(j)))
+	}
+	return res, nil
+}
+
+func (s *agentServer) WatchJob(req *bakpb.WatchJobRequest, stream bakpb.Agent_WatchJobServer) error {
+	j := s.jobs.find(req.Id)
+	if j == nil {
+		return status.Error(codes.NotFound, "no such job")
+	}
+
+	next := 0
+	for {
+		events, n, finished, changed := j.eventsFrom(next)
+		for _, e := range events {
+			if err := stream.Send(eventProto(e)); err != nil {
+				return err
+			}
+		}
+		next = n
+		if finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *agentServer) ListSnapshots(ctx context.Context, req *bakpb.ListSnapshotsRequest) (*bakpb.ListSnapshotsResponse, error) {
+	files, err := listBackups(s.jobs.dir)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &bakpb.ListSnapshotsResponse{}
+	for _, f := range files {
+		res.Snapshots = append(res.Snapshots, &bakpb.Snapshot{
+			Name:    f.Name,
+			Size:    f.Size,
+			ModTime: timestamppb.New(f.ModTime),
+			Archive: f.Archive,
+		})
+	}
+	return res, nil
+}
+
+func jobProto(s jobStatus) *bakpb.Job {
+	j := &bakpb.Job{
+		Id:           s.ID,
+		Args:         s.Args,
+		Outputs:      s.Outputs,
+		Status:       s.Status,
+		Started:      timestamppb.New(s.Started),
+		BytesWritten: s.BytesWritten,
+		Log:          s.Log,
+	}
+	if s.Finished != nil {
+		j.Finished = timestamppb.New(*s.Finished)
+		j.ExitCode = int32(*s.ExitCode)
+	}
+	return j
+}
+
+func eventProto(e progressEvent) *bakpb.ProgressEvent {
+	return &bakpb.ProgressEvent{
+		Event:   e.Event,
+		Time:    timestamppb.New(e.Time),
+		Name:    e.Name,
+		Size:    e.Size,
+		Bytes:   e.Bytes,
+		Message: e.Message,
+	}
+}
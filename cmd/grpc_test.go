@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sett17/bak/bakpb"
+)
+
+func TestRestoreArgs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "b.tar"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []*bakpb.RestoreRequest{
+		{Archive: "../b.tar", To: "x"},
+		{Archive: "missing.tar", To: "x"},
+		{Archive: "notes.txt", To: "x"},
+		{Archive: "b.tar", To: "../x"},
+		{Archive: "b.tar", To: "/tmp/x"},
+		{Archive: "b.tar", To: "out/x"},
+		{Archive: "b.tar", To: "x", OnConflict: "ask"},
+	} {
+		if _, err := restoreArgs(dir, req); err == nil {
+			t.Errorf("restoreArgs(%v) accepted", req)
+		}
+	}
+
+	args, err := restoreArgs(dir, &bakpb.RestoreRequest{Archive: "b.tar", To: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"restore", "--no-config", "--on-conflict=skip", "--to=x", "--", "b.tar"}
+	if !slices.Equal(args, want) {
+		t.Errorf("restoreArgs = %q, want %q", args, want)
+	}
+}
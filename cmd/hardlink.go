@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"archive/tar"
+	"os"
+	"sync"
+)
+
+// fileKey identifies a file by its device and inode.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// linkedFile is the entry a file with several links is stored as, which
+// the other links point to.
+type linkedFile struct {
+	name    string
+	written bool
+}
+
+// linkTracker remembers the files with several links in the archive being
+// written.
+type linkTracker struct {
+	mu    sync.Mutex
+	files map[fileKey]*linkedFile
+}
+
+var hardlinks linkTracker
+
+// reset forgets the files of the previous archive.
+func (t *linkTracker) reset() {
+	t.mu.Lock()
+	t.files = nil
+	t.mu.Unlock()
+}
+
+// find returns the entry of the file fi describes when it has several
+// links, and whether it is the first of them, stored as name. Zip archives
+// have no links, every one of them is stored in full.
+func (t *linkTracker) find(fi os.FileInfo, name string) (*linkedFile, bool) {
+	if zipOutput || !fi.Mode().IsRegular() {
+		return nil, true
+	}
+	key, ok := linkKey(fi)
+	if !ok {
+		return nil, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.files[key]; ok {
+		return l, false
+	}
+	if t.files == nil {
+		t.files = make(map[fileKey]*linkedFile)
+	}
+	l := &linkedFile{name: name}
+	t.files[key] = l
+	return l, true
+}
+
+func (t *linkTracker) written(l *linkedFile) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return l.written
+}
+
+// setWritten records that l was stored as name.
+func (t *linkTracker) setWritten(l *linkedFile, name string) {
+	t.mu.Lock()
+	l.name, l.written = name, true
+	t.mu.Unlock()
+}
+
+// linkLocal returns what prepares the file for storing as name. Files with
+// several links are stored once, the other links point to the first one.
+// Which one that is is decided here, in the order of the archive, as the
+// prefetcher prepares files in any order.
+func linkLocal(file, name string, fi os.FileInfo) func() func(aw archiveWriter) error {
+	l, first := hardlinks.find(fi, name)
+	switch {
+	case l == nil:
+		return func() func(aw archiveWriter) error {
+			return prepareLocal(file, name, fi)
+		}
+	case !first:
+		return func() func(aw archiveWriter) error {
+			return func(aw archiveWriter) error {
+				return writeHardlink(aw, l, file, name, fi)
+			}
+		}
+	}
+	return func() func(aw archiveWriter) error {
+		write := prepareLocal(file, name, fi)
+		return func(aw archiveWriter) error {
+			if err := write(aw); err != nil {
+				return err
+			}
+			hardlinks.setWritten(l, name)
+			return nil
+		}
+	}
+}
+
+// writeHardlink stores the file as a link to the entry l, or in full when
+// storing l failed.
+func writeHardlink(aw archiveWriter, l *linkedFile, file, name string, fi os.FileInfo) error {
+	if !hardlinks.written(l) {
+		if err := writeLocal(aw, file, name, fi); err != nil {
+			return err
+		}
+		hardlinks.setWritten(l, name)
+		return nil
+	}
+
+	hdr, err := localHeader(file, name, fi)
+	if err != nil {
+		return err
+	}
+	hdr.Typeflag = tar.TypeLink
+	hdr.Linkname = l.name
+	hdr.Size = 0
+	delete(hdr.PAXRecords, sparseMapKey)
+	if err := aw.writeEntry(hdr, nil); err != nil {
+		return err
+	}
+	addManifest(name, file, fi, nil)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cmd
+
+import "os"
+
+// linkKey reports false, the file info carries no inodes here.
+func linkKey(fi os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// preHooks and postHooks are the shell commands the config has bak run
+// before and after a backup.
+var (
+	preHooks  []string
+	postHooks []string
+)
+
+// hooksStarted is set once the pre hooks succeeded, the post hooks only
+// follow a backup that got that far.
+var hooksStarted bool
+
+// applyHooks takes the pre and post hooks of the mapping m. As with the
+// sources, the hooks of a profile replace those for all runs.
+func applyHooks(m *yaml.Node, path string) error {
+	for _, h := range []struct {
+		key   string
+		hooks *[]string
+	}{{"pre", &preHooks}, {"post", &postHooks}} {
+		v := mapValue(m, h.key)
+		if v == nil || *h.hooks != nil {
+			continue
+		}
+		// Not configValues, ~ is for the shell to expand.
+		var cmds []string
+		if err := v.Decode(&cmds); err != nil {
+			var cmd string
+			if v.Decode(&cmd) != nil {
+				return fmt.Errorf("%s:%d: %s: expected a command or a list of them", path, v.Line, h.key)
+			}
+			cmds = []string{cmd}
+		}
+		for _, c := range cmds {
+			if err := checkHook(c); err != nil {
+				return fmt.Errorf("%s:%d: %s: %w", path, v.Line, h.key, err)
+			}
+		}
+		*h.hooks = cmds
+	}
+	return nil
+}
+
+// hookVars are what hook commands can refer to, as in
+// curl -d "wrote {{.BytesWritten}} bytes" and ls -l {{.OutputPath}}.
+// Paths come quoted for the shell, so they are given without quotes of
+// their own. Before the backup only the outputs are known.
+type hookVars struct {
+	// OutputPath is the first output, OutputPaths all of them, each a
+	// word of its own.
+	OutputPath   string
+	OutputPaths  string
+	BytesWritten int64
+	Duration     time.Duration
+	ErrorCount   int
+}
+
+// hookTemplate parses the hook command c.
+func hookTemplate(c string) (*template.Template, error) {
+	return template.New("hook").Option("missingkey=error").Parse(c)
+}
+
+// checkHook reports what is wrong with the hook command c before it is
+// run: a broken template, or a variable there is none of.
+func checkHook(c string) error {
+	t, err := hookTemplate(c)
+	if err != nil {
+		return err
+	}
+	return t.Execute(io.Discard, hookVars{})
+}
+
+// newHookVars returns the variables for hooks of a run that started at
+// start and wrote outputs.
+func newHookVars(outputs []string, start time.Time) hookVars {
+	words := make([]string, len(outputs))
+	for i, o := range outputs {
+		words[i] = hookQuote(o)
+	}
+	v := hookVars{
+		OutputPaths:  strings.Join(words, " "),
+		BytesWritten: result.BytesOut,
+		Duration:     time.Since(start).Round(time.Millisecond),
+		ErrorCount:   len(result.Errors),
+	}
+	if len(words) > 0 {
+		v.OutputPath = words[0]
+	}
+	return v
+}
+
+// hookQuote makes s a single word for the shell hooks run in.
+func hookQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return shellQuote(s)
+}
+
+// runPreHooks runs the pre hooks in order and stops at the first that
+// fails.
+func runPreHooks() error {
+	vars := newHookVars(outputPaths, time.Now())
+	for _, c := range preHooks {
+		if err := runHook("pre", c, vars); err != nil {
+			return err
+		}
+	}
+	hooksStarted = true
+	return nil
+}
+
+// runPostHooks runs all post hooks, also after a failed one, for the run
+// that started at start.
+func runPostHooks(start time.Time) error {
+	if !hooksStarted {
+		return nil
+	}
+	var outputs []string
+	for _, o := range result.Outputs {
+		if o.Error == "" {
+			outputs = append(outputs, o.Path)
+		}
+	}
+	vars := newHookVars(outputs, start)
+
+	var errs []error
+	for _, c := range postHooks {
+		if err := runHook("post", c, vars); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runHook runs the command c in the shell, with vars filled in.
+func runHook(kind, c string, vars hookVars) error {
+	t, err := hookTemplate(c)
+	if err != nil {
+		return fmt.Errorf("%s hook %q: %w", kind, c, err)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, vars); err != nil {
+		return fmt.Errorf("%s hook %q: %w", kind, c, err)
+	}
+	c = sb.String()
+
+	if dryRun {
+		// With --json stdout is for the result alone.
+		if jsonOutput {
+			printInfo("Would run %s hook: %s", kind, c)
+		} else {
+			fmt.Printf("Would run %s hook: %s\n", kind, c)
+		}
+		return nil
+	}
+	printInfo("Running %s hook: %s", kind, c)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", c)
+	} else {
+		cmd = exec.Command("sh", "-c", c)
+	}
+	// With --json stdout is for the result alone.
+	var stdout io.Writer = os.Stdout
+	if jsonOutput {
+		stdout = os.Stderr
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q: %w", kind, c, err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var respectGitignore bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&respectGitignore, "respect-gitignore", false, "Skip files ignored by .gitignore files and .git/info/exclude")
+}
+
+// ignoreFileNames returns the ignore files read in every directory of a
+// source tree.
+func ignoreFileNames() []string {
+	if respectGitignore {
+		return []string{".bakignore", ".gitignore", filepath.Join(".git", "info", "exclude")}
+	}
+	return []string{".bakignore"}
+}
+
+// ignoreRule is one line of an ignore file, in gitignore syntax.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	return (isDir || !r.dirOnly) && r.re.MatchString(rel)
+}
+
+// compileIgnoreRule translates a gitignore line into a rule. It returns
+// false for blank lines and comments.
+func compileIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+
+	var r ignoreRule
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// A slash anywhere but at the end anchors the pattern to the directory
+	// of the ignore file, otherwise it matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "**") && i+2 == len(line):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			b.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+func readIgnoreFile(name string) []ignoreRule {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := compileIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// ignoreMatcher applies the ignore files found in a source tree. Files are
+// read the first time a path below their directory is checked.
+type ignoreMatcher struct {
+	root  string
+	rules map[string][]ignoreRule
+	// git limits the tree to the files git knows about, nil unless
+	// --git-tracked is set and root is a directory.
+	git *gitFiles
+}
+
+func newIgnoreMatcher(root string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{root: root, rules: map[string][]ignoreRule{}}
+	if gitTracked {
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			g, err := listGitFiles(root)
+			if err != nil {
+				return nil, err
+			}
+			m.git = g
+		}
+	}
+	return m, nil
+}
+
+func (m *ignoreMatcher) load(dir string) []ignoreRule {
+	rules, ok := m.rules[dir]
+	if !ok {
+		for _, name := range ignoreFileNames() {
+			rules = append(rules, readIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), name))...)
+		}
+		m.rules[dir] = rules
+	}
+	return rules
+}
+
+// ignored reports whether file is ignored. Like git, rules in deeper
+// directories and later lines take precedence.
+func (m *ignoreMatcher) ignored(file string, isDir bool) bool {
+	rel, err := filepath.Rel(m.root, file)
+	if err != nil || rel == "." {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if m.git != nil && !m.git.contains(rel, isDir) {
+		return true
+	}
+
+	ignored := false
+	dir := "."
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		sub := strings.Join(parts[i:], "/")
+		for _, r := range m.load(dir) {
+			if r.match(sub, isDir) {
+				ignored = !r.negate
+			}
+		}
+		dir = path.Join(dir, part)
+	}
+	return ignored
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var writeIndex bool
+
+// indexSuffix is added to the name of an archive for its index.
+const indexSuffix = ".bak.idx"
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&writeIndex, "index", false, "Write an index of the entries next to local tar outputs, as NAME"+indexSuffix+", so they can be listed and read without going through the whole archive")
+}
+
+// archiveIndex lists the entries of a tar archive with where they start.
+// The archive is compressed in gzip members of Block bytes of the tar
+// stream each, Members holds where they start in the file, so reading can
+// start at the member an entry is in.
+type archiveIndex struct {
+	Version int          `json:"version"`
+	Size    int64        `json:"size"`
+	Block   int64        `json:"block"`
+	Members []int64      `json:"members"`
+	Entries []indexEntry `json:"entries"`
+}
+
+// indexEntry is an entry of an archive and the offset of its header in the
+// uncompressed tar stream.
+type indexEntry struct {
+	archiveEntry
+	Offset int64  `json:"offset"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// indexedEntry collects the index entry of an entry while it is written.
+type indexedEntry struct {
+	e indexEntry
+	h hash.Hash
+}
+
+func (a *tarArchive) startIndexEntry(hdr *tar.Header, r io.Reader) (*indexedEntry, io.Reader, error) {
+	// Padding of the previous entry goes out first, so the header starts
+	// where the count is.
+	if err := a.tw.Flush(); err != nil {
+		return nil, r, err
+	}
+	fi := hdr.FileInfo()
+	ie := &indexedEntry{e: indexEntry{
+		archiveEntry: archiveEntry{Name: hdr.Name, Size: hdr.Size, Mode: fi.Mode(), ModTime: hdr.ModTime},
+		Offset:       a.count.n,
+	}}
+	if hdr.Typeflag == tar.TypeLink {
+		ie.e.Link = hdr.Linkname
+	}
+	if r != nil && hdr.Typeflag == tar.TypeReg {
+		ie.h = sha256.New()
+		r = io.TeeReader(r, ie.h)
+	}
+	return ie, r, nil
+}
+
+func (a *tarArchive) endIndexEntry(ie *indexedEntry) {
+	if ie.h != nil {
+		ie.e.SHA256 = hex.EncodeToString(ie.h.Sum(nil))
+	}
+	a.index.Entries = append(a.index.Entries, ie.e)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeIndex writes the index next to every local output that was
+// written. A missing index only makes reading slower, so failing to write
+// one is a warning.
+func (m *multiOutput) writeIndex(idx *archiveIndex) {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		printWarning("--index: %v", err)
+		return
+	}
+	for _, d := range m.dests {
+		if d.err != nil || isRemoteOutput(d.path) {
+			continue
+		}
+		if err := writeIndexFile(d.path+indexSuffix, data); err != nil {
+			printWarning("--index: %v", err)
+		}
+	}
+}
+
+func writeIndexFile(path string, data []byte) error {
+	out, err := createLocal(path)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.abort()
+		return err
+	}
+	return out.Close()
+}
+
+// readIndex reads the index of the archive at path. It is an error when
+// there is none or it belongs to another version of the archive.
+func readIndex(path string) (*archiveIndex, error) {
+	data, err := os.ReadFile(path + indexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var idx archiveIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", path+indexSuffix, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Version != 1 || idx.Size != info.Size() || idx.Block <= 0 || len(idx.Members) == 0 {
+		return nil, fmt.Errorf("%s does not match %s", filepath.Base(path+indexSuffix), filepath.Base(path))
+	}
+	return &idx, nil
+}
+
+// archiveEntries returns the entries of the archive at path, from its
+// index if it has one.
+func archiveEntries(path string) ([]archiveEntry, error) {
+	if idx, err := readIndex(path); err == nil {
+		entries := make([]archiveEntry, len(idx.Entries))
+		for i, e := range idx.Entries {
+			entries[i] = e.archiveEntry
+		}
+		return entries, nil
+	}
+
+	var entries []archiveEntry
+	err := walkArchive(path, func(e archiveEntry, r io.Reader) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// find returns the entry of the regular file name, nil if there is none.
+func (idx *archiveIndex) find(name string) *indexEntry {
+	for i := range idx.Entries {
+		if idx.Entries[i].Name == name && idx.Entries[i].Mode.IsRegular() {
+			return &idx.Entries[i]
+		}
+	}
+	return nil
+}
+
+// errNoIndex means an archive has no usable index.
+var errNoIndex = errors.New("no index")
+
+// readIndexedEntry calls fn with the contents of the regular file name in
+// the archive at path, going straight to it with the index of the archive.
+// A hard link is read from the entry it points to. found is false when the
+// index has no such entry.
+func readIndexedEntry(path, name string, fn func(e archiveEntry, r io.Reader) error) (found bool, err error) {
+	idx, err := readIndex(path)
+	if err != nil {
+		return false, errNoIndex
+	}
+	entry := idx.find(name)
+	if entry == nil {
+		return false, nil
+	}
+	if entry.Link != "" {
+		target := idx.find(entry.Link)
+		if target == nil || target.Link != "" {
+			return true, fmt.Errorf("%s: link to %s, which is not a file in the archive", name, entry.Link)
+		}
+		entry = target
+	}
+	name = entry.Name
+
+	member := entry.Offset / idx.Block
+	if member >= int64(len(idx.Members)) {
+		return true, fmt.Errorf("%s: index points past the archive", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(idx.Members[member], io.SeekStart); err != nil {
+		return true, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return true, err
+	}
+	defer gz.Close()
+	if _, err := io.CopyN(io.Discard, gz, entry.Offset-member*idx.Block); err != nil {
+		return true, err
+	}
+
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return true, err
+	}
+	if hdr.Name != name {
+		return true, fmt.Errorf("%s: index points to %s instead", name, hdr.Name)
+	}
+	return true, fn(entry.archiveEntry, tr)
+}
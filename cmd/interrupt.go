@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// interrupted is set once SIGINT or SIGTERM arrived.
+var interrupted atomic.Bool
+
+var errInterrupted = errors.New("interrupted, the unfinished output was removed")
+
+// handleSignals makes SIGINT and SIGTERM stop the backup cleanly: the
+// current entry is abandoned, partial outputs are removed and bak exits
+// with exitInterrupted. A second signal exits at once.
+func handleSignals() (stop func()) {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range ch {
+			if interrupted.Swap(true) {
+				bar.clear()
+				os.Exit(exitInterrupted)
+			}
+			printWarning("Interrupted, cleaning up; interrupt again to quit at once")
+		}
+	}()
+	return func() { signal.Stop(ch) }
+}
+
+// interruptedError gives err the exit code of an interruption if it is one.
+func interruptedError(err error) error {
+	if errors.Is(err, errInterrupted) {
+		return withExitCode(exitInterrupted, err)
+	}
+	return err
+}
+
+// interruptArchive stops writing an archive once bak is interrupted, also
+// in the middle of an entry.
+type interruptArchive struct {
+	archiveWriter
+}
+
+func (a interruptArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if interrupted.Load() {
+		return errInterrupted
+	}
+	if r != nil {
+		r = interruptReader{r}
+	}
+	return a.archiveWriter.writeEntry(hdr, r)
+}
+
+// interruptReader fails with errInterrupted once bak is interrupted.
+type interruptReader struct {
+	r io.Reader
+}
+
+func (r interruptReader) Read(p []byte) (int, error) {
+	if interrupted.Load() {
+		return 0, errInterrupted
+	}
+	return r.r.Read(p)
+}
+
+// aborter is an output that can be dropped instead of closed, leaving
+// nothing behind that looks like a complete archive.
+type aborter interface {
+	abort()
+}
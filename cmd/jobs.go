@@ -0,0 +1,304 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var jobs int
+
+// prefetchMax is the largest file that is read ahead into memory with
+// --jobs. Larger files are streamed into the archive as before.
+const prefetchMax = 1 << 20
+
+// prefetchAhead is how many entries per job may wait for their turn.
+const prefetchAhead = 4
+
+// Files up to smallFile are prepared in batches of up to batchFiles files
+// or batchBytes bytes, read one after the other on one goroutine. Trees of
+// thousands of tiny files spend more on handing every file to a goroutine
+// than on reading it.
+const (
+	smallFile  = 64 << 10
+	batchFiles = 256
+	batchBytes = 1 << 20
+)
+
+// autoJobsMax is the most files read at once when --jobs is left to bak.
+const autoJobsMax = 16
+
+// tuneWindow is how long the throughput is measured before the number of
+// files read at once is changed.
+const tuneWindow = 250 * time.Millisecond
+
+func init() {
+	rootCmd.PersistentFlags().IntVarP(&jobs, "jobs", "j", 0, "Read, hash and compress up to N files at once; tar archives are compressed in parallel blocks (default: tuned while running)")
+}
+
+func setJobs() error {
+	if jobs < 0 {
+		return fmt.Errorf("--jobs: %d is not a number of jobs", jobs)
+	}
+	return nil
+}
+
+// parallel reports whether files are read and compressed on several
+// goroutines, either as --jobs says or because it was left to bak.
+func parallel() bool {
+	return jobs > 1 || jobs == 0
+}
+
+// compressJobs is the number of goroutines compressing and hashing. Left
+// to bak, there is one per CPU.
+func compressJobs() int {
+	n := jobs
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+	if memoryJobs > 0 {
+		n = min(n, memoryJobs)
+	}
+	return n
+}
+
+// prefetcher prepares entries on several goroutines ahead of the archive,
+// while they are still written to it in order, by the goroutine that adds
+// them. It prepares up to --jobs entries at once, or a number that tune
+// adjusts to the throughput of the disk.
+type prefetcher struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	running int
+	max     int
+	queue   []prefetched
+
+	batch     []func() func() error
+	batchSize int64
+
+	auto      bool
+	bytes     int64
+	since     time.Time
+	lastRate  float64
+	direction int
+}
+
+type prefetched struct {
+	size int64
+	res  chan func() error
+}
+
+// newPrefetcher returns nil with --jobs 1, which adds every entry
+// directly.
+func newPrefetcher() *prefetcher {
+	if !parallel() {
+		return nil
+	}
+	p := &prefetcher{limit: jobs, max: jobs}
+	if jobs == 0 {
+		// Reading starts out a little parallel, which no disk minds, and
+		// tune takes it from there.
+		p.auto = true
+		p.limit = 2
+		p.max = autoJobsMax
+		p.direction = 1
+		p.since = time.Now()
+	}
+	if memoryJobs > 0 {
+		p.max = min(p.max, memoryJobs)
+		p.limit = min(p.limit, p.max)
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// add runs prepare in the background and the function it returns once the
+// entries added before are written. size is how much there is to read for
+// the entry. The error is that of an earlier entry.
+func (p *prefetcher) add(size int64, prepare func() func() error) error {
+	if p == nil {
+		return prepare()()
+	}
+
+	if size <= smallFile {
+		p.batch = append(p.batch, prepare)
+		p.batchSize += max(size, 4096)
+		if len(p.batch) < batchFiles && p.batchSize < batchBytes {
+			return nil
+		}
+		return p.addBatch()
+	}
+	if err := p.addBatch(); err != nil {
+		return err
+	}
+	return p.start(size, prepare)
+}
+
+// addBatch starts preparing the small entries collected so far as one.
+func (p *prefetcher) addBatch() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	batch, size := p.batch, p.batchSize
+	p.batch, p.batchSize = nil, 0
+	return p.start(size, func() func() error {
+		writes := make([]func() error, len(batch))
+		for i, prepare := range batch {
+			writes[i] = prepare()
+		}
+		return func() error {
+			for _, write := range writes {
+				if err := write(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+}
+
+// start runs prepare on a goroutine as soon as fewer than the limit are
+// running.
+func (p *prefetcher) start(size int64, prepare func() func() error) error {
+	res := make(chan func() error, 1)
+	p.mu.Lock()
+	for p.running >= p.limit {
+		p.cond.Wait()
+	}
+	p.running++
+	p.mu.Unlock()
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.running--
+			p.cond.Signal()
+			p.mu.Unlock()
+		}()
+		res <- prepare()
+	}()
+	p.queue = append(p.queue, prefetched{size: size, res: res})
+
+	if len(p.queue) > prefetchAhead*p.max {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *prefetcher) next() error {
+	e := p.queue[0]
+	p.queue = p.queue[1:]
+	err := (<-e.res)()
+	if p.auto {
+		p.tune(e.size)
+	}
+	return err
+}
+
+// tune counts the bytes of an entry written and, once per tuneWindow,
+// moves the number of entries prepared at once a step up or down: on in
+// the same direction while the throughput grows, back when it drops. Disks
+// that seek, like HDDs, settle on few, SSDs and network filesystems, which
+// answer faster the more they are asked at once, on more.
+func (p *prefetcher) tune(size int64) {
+	// Every entry costs at least a block, whatever its size.
+	p.bytes += max(size, 4096)
+	elapsed := time.Since(p.since)
+	if elapsed < tuneWindow {
+		return
+	}
+	rate := float64(p.bytes) / elapsed.Seconds()
+	switch {
+	case rate > p.lastRate*1.1:
+	case rate < p.lastRate*0.9:
+		p.direction = -p.direction
+	default:
+		// No gain, the same is done with fewer.
+		p.direction = -1
+	}
+	p.lastRate = rate
+	p.bytes = 0
+	p.since = time.Now()
+
+	p.mu.Lock()
+	limit := p.limit + p.direction
+	if limit < 1 || limit > p.max {
+		// Probe the other way from the bounds, the disk may have changed.
+		p.direction = -p.direction
+		limit = p.limit + p.direction
+	}
+	if limit != p.limit {
+		if debugTiming {
+			printInfo("Reading files %d at a time, after %s/s", limit, formatSize(int64(rate)))
+		}
+		p.limit = limit
+		p.cond.Broadcast()
+	}
+	p.mu.Unlock()
+}
+
+// flush writes the entries still waiting.
+func (p *prefetcher) flush() error {
+	if p == nil {
+		return nil
+	}
+	if err := p.addBatch(); err != nil {
+		return err
+	}
+	for len(p.queue) > 0 {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareLocal does what can be done for storing a file before its turn
+// comes: with --jobs, small regular files are read and hashed here. The
+// returned function writes the entry.
+func prepareLocal(file, name string, fi os.FileInfo) func(aw archiveWriter) error {
+	if !parallel() || !fi.Mode().IsRegular() || fi.Size() > prefetchMax {
+		return func(aw archiveWriter) error {
+			return writeLocal(aw, file, name, fi)
+		}
+	}
+
+	data, sr, err := readAhead(file, fi.Size())
+	if err != nil {
+		return func(aw archiveWriter) error { return err }
+	}
+	r, h := manifestHash(bytes.NewReader(data))
+	if h != nil {
+		io.Copy(io.Discard, r)
+	}
+
+	return func(aw archiveWriter) error {
+		hdr, err := localHeader(file, name, fi)
+		if err != nil {
+			return err
+		}
+		if err := aw.writeEntry(hdr, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		addManifest(name, file, fi, h)
+		return sr.archived(file)
+	}
+}
+
+// readAhead reads the size bytes of a file the way writeLocal would.
+func readAhead(file string, size int64) ([]byte, *sizedReader, error) {
+	f, err := os.Open(longPath(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	sr := &sizedReader{r: limitReads(f), n: size}
+	data := make([]byte, size)
+	_, err = io.ReadFull(sr, data)
+	return data, sr, err
+}
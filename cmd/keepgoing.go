@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var keepGoing bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&keepGoing, "keep-going", "k", false, "Leave out files that cannot be read instead of stopping, and report them at the end")
+}
+
+// fileError is a file that could not be archived properly.
+type fileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// archivedError is a problem with a file that has already been stored in
+// the archive, so only reporting it is left to do.
+type archivedError struct {
+	err error
+}
+
+func (e *archivedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *archivedError) Unwrap() error {
+	return e.err
+}
+
+// fileFailed handles a problem with a single file. With --keep-going it is
+// recorded and the backup goes on, otherwise it ends the backup.
+func fileFailed(path string, err error) error {
+	if err == nil || !keepGoing || errors.Is(err, errInterrupted) {
+		return err
+	}
+
+	result.FileErrors = append(result.FileErrors, fileError{Path: path, Error: err.Error()})
+	setExitCode(exitWarnings)
+	var ae *archivedError
+	if errors.As(err, &ae) {
+		printWarning("%v", err)
+	} else {
+		printWarning("%v, left out", err)
+	}
+	return nil
+}
+
+// sizedReader reads exactly n bytes, as announced in the entry header. A
+// file that shrinks or fails while it is read is padded with zeros and the
+// cause kept in err, one that grows is cut off.
+type sizedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+// archived returns the problem reading file, if there was one, once its
+// entry has been stored.
+func (s *sizedReader) archived(file string) error {
+	if s.err != nil {
+		return &archivedError{fmt.Errorf("%s: %w, stored padded with zeros", file, s.err)}
+	}
+	return nil
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.n {
+		p = p[:s.n]
+	}
+
+	if s.err == nil {
+		n, err := s.r.Read(p)
+		s.n -= int64(n)
+		if err == io.EOF && s.n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			s.err = err
+		}
+		if n > 0 || s.err == nil {
+			return n, nil
+		}
+	}
+
+	clear(p)
+	s.n -= int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	verbosity int
+	quiet     bool
+	logFile   string
+	logFormat string
+	colorMode string
+)
+
+// logger receives every diagnostic. Until setLogging runs it prints plain
+// messages to stderr.
+var logger = slog.New(&consoleHandler{w: os.Stderr, level: slog.LevelInfo})
+
+func init() {
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "List every entry as it is archived, -vv adds mode and size")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print nothing but errors")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Also append all messages to this file")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Format of log records, text or json (default text in --log-file and plain messages on the terminal)")
+	rootCmd.PersistentFlags().StringVar(&colorMode, "color", "auto", "Color messages: auto, always or never; auto colors only on a terminal and when NO_COLOR is not set")
+}
+
+// setLogging sets up the logger from the flags. Messages go to stderr, so
+// stdout only carries what was asked for, like --json or --dry-run output.
+func setLogging() error {
+	level := slog.LevelInfo
+	switch {
+	case quiet:
+		level = slog.LevelError
+	case verbosity > 0:
+		level = slog.LevelDebug
+	}
+
+	color, err := useColor()
+	if err != nil {
+		return err
+	}
+
+	var console slog.Handler = &consoleHandler{w: os.Stderr, level: level, color: color}
+	switch logFormat {
+	case "":
+	case "text":
+		if logFile == "" {
+			console = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+		}
+	case "json":
+		if logFile == "" {
+			console = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+		}
+	default:
+		return fmt.Errorf("--log-format: unknown format %q, use text or json", logFormat)
+	}
+
+	if logFile == "" {
+		logger = slog.New(console)
+		return nil
+	}
+
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("--log-file: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var file slog.Handler = slog.NewTextHandler(f, opts)
+	if logFormat == "json" {
+		file = slog.NewJSONHandler(f, opts)
+	}
+	logger = slog.New(teeHandler{console, file})
+	return nil
+}
+
+// useColor decides from --color and the environment whether messages on
+// the terminal are colored.
+func useColor() (bool, error) {
+	switch colorMode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(os.Stderr), nil
+	}
+	return false, fmt.Errorf("--color: unknown mode %q, use auto, always or never", colorMode)
+}
+
+// printInfo logs a status message.
+func printInfo(format string, a ...any) {
+	logger.Info(fmt.Sprintf(format, a...))
+}
+
+// successKey marks the context of messages that tell something finished
+// well, so the console can show them in green.
+type successKey struct{}
+
+// printSuccess logs a status message about something that worked.
+func printSuccess(format string, a ...any) {
+	ctx := context.WithValue(context.Background(), successKey{}, true)
+	logger.InfoContext(ctx, fmt.Sprintf(format, a...))
+}
+
+// printDebug logs a message only shown with -v.
+func printDebug(format string, a ...any) {
+	logger.Debug(fmt.Sprintf(format, a...))
+}
+
+func printWarning(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	progressEvents.emit(progressEvent{Event: "warning", Message: msg})
+	logger.Warn(msg)
+}
+
+// printError reports an error. Errors are shown even with --quiet, and
+// collected for the result.
+func printError(err error) {
+	progressEvents.emit(progressEvent{Event: "error", Message: err.Error()})
+	result.Errors = append(result.Errors, err.Error())
+	setExitCode(exitFatal)
+	logger.Error(err.Error())
+}
+
+// ANSI escapes used by the console.
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorGreen  = "\x1b[32m"
+	colorReset  = "\x1b[0m"
+)
+
+// consoleHandler prints records the way bak always has: the message alone,
+// prefixed with "Warning:" or "Error:" where it applies. With color set,
+// errors are red, warnings yellow and successes green.
+type consoleHandler struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level slog.Level
+	color bool
+	attrs []slog.Attr
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b strings.Builder
+	color := ""
+	switch {
+	case r.Level >= slog.LevelError:
+		color = colorRed
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		color = colorYellow
+		b.WriteString("Warning: ")
+	case ctx.Value(successKey{}) != nil:
+		color = colorGreen
+	}
+	b.WriteString(r.Message)
+
+	add := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	line := b.String()
+	if h.color && color != "" {
+		line = color + line + colorReset
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bar.clear()
+	_, err := io.WriteString(h.w, line+"\n")
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &consoleHandler{w: h.w, level: h.level, color: h.color, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// teeHandler passes records on to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
+
+// verboseArchive lists the entries written to an archive for -v.
+type verboseArchive struct {
+	archiveWriter
+}
+
+func (v verboseArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if verbosity > 1 {
+		printDebug("%s %10s %s", hdr.FileInfo().Mode(), formatSize(hdr.Size), hdr.Name)
+	} else {
+		printDebug("%s", hdr.Name)
+	}
+	return v.archiveWriter.writeEntry(hdr, r)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package cmd
+
+// longPath returns path as it is, only Windows limits their length.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is how long a path may be before Windows needs it extended,
+// MAX_PATH less the room CreateDirectory keeps for a file name.
+const maxPath = 248
+
+// longPath returns path in the form Windows takes paths of any length in,
+// \\?\ followed by the absolute path, when it is too long otherwise. The
+// os package does the same only for paths that are absolute already,
+// while walks and restores mostly deal in relative ones, as in deep
+// node_modules trees.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	// The extended form takes no . or .. and no forward slashes, which
+	// Abs cleans away.
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
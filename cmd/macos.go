@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The records bsdtar keeps the creation time and the file flags of macOS
+// in. Resource forks and Finder info are extended attributes there, which
+// --xattrs takes care of.
+const (
+	paxCreationTime = "LIBARCHIVE.creationtime"
+	paxFileFlags    = "SCHILY.fflags"
+)
+
+// addMacMetadata stores the creation time and file flags of the file fi
+// describes in the records of hdr, where the platform has them.
+func addMacMetadata(hdr *tar.Header, fi fs.FileInfo) {
+	btime, flags := birthTime(fi), fileFlags(fi)
+	if btime.IsZero() && flags == "" {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string, 2)
+	}
+	if !btime.IsZero() {
+		hdr.PAXRecords[paxCreationTime] = formatPAXTime(btime)
+	}
+	if flags != "" {
+		hdr.PAXRecords[paxFileFlags] = flags
+	}
+}
+
+// headerMacMetadata returns the creation time and file flags stored in hdr.
+func headerMacMetadata(hdr *tar.Header) (time.Time, string) {
+	var btime time.Time
+	if s, ok := hdr.PAXRecords[paxCreationTime]; ok {
+		t, err := parsePAXTime(s)
+		if err != nil {
+			printWarning("%s: %s: %v", hdr.Name, paxCreationTime, err)
+		}
+		btime = t
+	}
+	return btime, hdr.PAXRecords[paxFileFlags]
+}
+
+// formatPAXTime writes t as seconds with a fraction, as PAX times are.
+func formatPAXTime(t time.Time) string {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	sign := ""
+	if sec < 0 {
+		// The fraction counts away from zero as well.
+		sign, sec, nsec = "-", -(sec + 1), 1e9-nsec
+	}
+	return fmt.Sprintf("%s%d.%09d", sign, sec, nsec)
+}
+
+func parsePAXTime(s string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if strings.HasPrefix(secs, "-") {
+		nsec = -nsec
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// The file flags of macOS and their names in SCHILY.fflags. Those starting
+// with s can only be set by root.
+var fileFlagNames = []struct {
+	name string
+	flag uint32
+}{
+	{"nodump", 0x1},
+	{"uchg", 0x2},
+	{"uappnd", 0x4},
+	{"opaque", 0x8},
+	{"hidden", 0x8000},
+	{"arch", 0x10000},
+	{"schg", 0x20000},
+	{"sappnd", 0x40000},
+}
+
+func formatFileFlags(flags uint32) string {
+	var names []string
+	for _, f := range fileFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// parseFileFlags reads the names of flags, leaving out those this platform
+// does not know.
+func parseFileFlags(s string) uint32 {
+	var flags uint32
+	for _, name := range strings.Split(s, ",") {
+		for _, f := range fileFlagNames {
+			if strings.TrimSpace(name) == f.name {
+				flags |= f.flag
+			}
+		}
+	}
+	return flags
+}
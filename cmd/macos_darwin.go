@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthTime returns when the file fi describes was created.
+func birthTime(fi fs.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(st.Birthtimespec.Unix())
+}
+
+// fileFlags returns the flags of the file fi describes, as chflags names
+// them.
+func fileFlags(fi fs.FileInfo) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return formatFileFlags(st.Flags)
+}
+
+// setBirthTime sets the creation time of path, not following symlinks.
+func setBirthTime(path string, t time.Time) error {
+	attrs := unix.Attrlist{Bitmapcount: unix.ATTR_BIT_MAP_COUNT, Commonattr: unix.ATTR_CMN_CRTIME}
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(t.Nanosecond()))
+	return unix.Setattrlist(path, &attrs, buf, unix.FSOPT_NOFOLLOW)
+}
+
+func setFileFlags(path, flags string) error {
+	return unix.Chflags(path, int(parseFileFlags(flags)))
+}
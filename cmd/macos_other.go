@@ -0,0 +1,26 @@
+//go:build !darwin
+
+package cmd
+
+import (
+	"io/fs"
+	"time"
+)
+
+// birthTime returns the zero time, creation times are not kept here.
+func birthTime(fi fs.FileInfo) time.Time {
+	return time.Time{}
+}
+
+// fileFlags returns "", file flags are not kept here.
+func fileFlags(fi fs.FileInfo) string {
+	return ""
+}
+
+func setBirthTime(path string, t time.Time) error {
+	return nil
+}
+
+func setFileFlags(path, flags string) error {
+	return nil
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	manifestFile   string
+	manifestFormat string
+	fromManifest   string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&manifestFile, "manifest", "", "Write a list of every archived path with size, mtime, mode and SHA-256 to this file")
+	rootCmd.PersistentFlags().StringVar(&manifestFormat, "manifest-format", "", "Format of --manifest, json or csv (default by the file's extension, else json)")
+	rootCmd.PersistentFlags().StringVar(&fromManifest, "from-manifest", "", "Back up exactly the files listed in a manifest written by --manifest, under the same names")
+}
+
+// manifestEntry is a path archived by the run, as listed by --manifest.
+type manifestEntry struct {
+	// Path is the name in the archive, Source where it was read from.
+	Path    string    `json:"path"`
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Mode    string    `json:"mode"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// manifest is what --manifest writes as JSON.
+type manifest struct {
+	Created time.Time       `json:"created"`
+	Sources []string        `json:"sources"`
+	Entries []manifestEntry `json:"entries"`
+}
+
+var manifestEntries = []manifestEntry{}
+
+func setManifest() error {
+	if manifestFile == "" {
+		return nil
+	}
+	switch manifestFormat {
+	case "":
+		manifestFormat = "json"
+		if strings.HasSuffix(strings.ToLower(manifestFile), ".csv") {
+			manifestFormat = "csv"
+		}
+	case "json", "csv":
+	default:
+		return fmt.Errorf("--manifest-format: unknown format %q, use json or csv", manifestFormat)
+	}
+	return nil
+}
+
+// manifestHash returns r, hashed along the way when a manifest is written,
+// and the hash, which is nil without one. With --jobs, the hashing is done
+// on a goroutine of its own, beside the compression of what is read.
+func manifestHash(r io.Reader) (io.Reader, hash.Hash) {
+	if manifestFile == "" || dryRun || r == nil {
+		return r, nil
+	}
+	if compressJobs() > 1 {
+		p := newHashPipe(sha256.New())
+		return &hashPipeReader{r: r, p: p}, p
+	}
+	h := sha256.New()
+	return io.TeeReader(r, h), h
+}
+
+// hashPipe feeds a hash on a goroutine. Sum waits for everything sent to
+// it to be hashed.
+type hashPipe struct {
+	hash.Hash
+	ch     chan *[]byte
+	done   chan struct{}
+	closed sync.Once
+}
+
+func newHashPipe(h hash.Hash) *hashPipe {
+	p := &hashPipe{Hash: h, ch: make(chan *[]byte, 4), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		for bp := range p.ch {
+			p.Hash.Write(*bp)
+			*bp = (*bp)[:cap(*bp)]
+			bufferPool.Put(bp)
+		}
+	}()
+	return p
+}
+
+// send hands a copy of b to the goroutine.
+func (p *hashPipe) send(b []byte) {
+	bp := bufferPool.Get().(*[]byte)
+	if cap(*bp) < len(b) {
+		*bp = make([]byte, len(b))
+	}
+	*bp = append((*bp)[:0], b...)
+	p.ch <- bp
+}
+
+func (p *hashPipe) finish() {
+	p.closed.Do(func() { close(p.ch) })
+	<-p.done
+}
+
+func (p *hashPipe) Sum(b []byte) []byte {
+	p.finish()
+	return p.Hash.Sum(b)
+}
+
+// hashPipeReader sends what is read from r to a hashPipe.
+type hashPipeReader struct {
+	r   io.Reader
+	p   *hashPipe
+	eof bool
+}
+
+func (h *hashPipeReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	if n > 0 && !h.eof {
+		h.p.send(b[:n])
+	}
+	if err != nil && !h.eof {
+		// Nothing follows, the goroutine can end even if the hash is
+		// never asked for.
+		h.eof = true
+		h.p.finish()
+	}
+	return n, err
+}
+
+// addManifest records an archived path for --manifest. h holds the
+// contents of a regular file.
+func addManifest(name, source string, fi fs.FileInfo, h hash.Hash) {
+	if manifestFile == "" || dryRun || droppedName(name) {
+		return
+	}
+	e := manifestEntry{
+		Path:    name,
+		Source:  source,
+		ModTime: fi.ModTime().UTC(),
+		Mode:    fi.Mode().String(),
+	}
+	if fi.Mode().IsRegular() {
+		e.Size = fi.Size()
+	}
+	if h != nil {
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	manifestEntries = append(manifestEntries, e)
+}
+
+// manifestNames holds the archive names of the sources read from
+// --from-manifest.
+var manifestNames = map[string]string{}
+
+// readManifest returns the sources listed in the manifest at name and
+// remembers their names in the archive. Directories are left out, the
+// files in them are listed on their own. Sources that are gone are
+// reported and left out as well.
+func readManifest(name string) ([]string, error) {
+	var entries []manifestEntry
+	var err error
+	if strings.HasSuffix(strings.ToLower(name), ".csv") {
+		entries, err = readManifestCSV(name)
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(name); err == nil {
+			var m manifest
+			err = json.Unmarshal(data, &m)
+			entries = m.Entries
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--from-manifest: %w", err)
+	}
+
+	var sources []string
+	for _, e := range entries {
+		if e.Source == "" || strings.HasPrefix(e.Mode, "d") {
+			continue
+		}
+		if !isRemoteSource(e.Source) {
+			if _, err := os.Lstat(e.Source); err != nil {
+				result.FileErrors = append(result.FileErrors, fileError{Path: e.Source, Error: err.Error()})
+				setExitCode(exitWarnings)
+				printWarning("%s is listed in the manifest, but %v", e.Source, err)
+				continue
+			}
+		}
+		sources = append(sources, e.Source)
+		manifestNames[e.Source] = e.Path
+	}
+	return sources, nil
+}
+
+func readManifestCSV(name string) ([]manifestEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	for _, h := range []string{"path", "source", "mode"} {
+		if _, ok := col[h]; !ok {
+			return nil, fmt.Errorf("%s: no %s column", name, h)
+		}
+	}
+
+	var entries []manifestEntry
+	for _, row := range rows[1:] {
+		entries = append(entries, manifestEntry{Path: row[col["path"]], Source: row[col["source"]], Mode: row[col["mode"]]})
+	}
+	return entries, nil
+}
+
+// writeManifest writes the manifest for --manifest, if asked for.
+func writeManifest(sources []string) error {
+	if manifestFile == "" || dryRun {
+		return nil
+	}
+
+	out, err := createLocal(manifestFile)
+	if err != nil {
+		return fmt.Errorf("--manifest: %w", err)
+	}
+	if manifestFormat == "csv" {
+		err = writeManifestCSV(out)
+	} else {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest{Created: time.Now().UTC(), Sources: sources, Entries: manifestEntries})
+	}
+	if err != nil {
+		out.abort()
+		return fmt.Errorf("--manifest: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("--manifest: %w", err)
+	}
+	return nil
+}
+
+func writeManifestCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "source", "size", "mtime", "mode", "sha256"})
+	for _, e := range manifestEntries {
+		cw.Write([]string{e.Path, e.Source, strconv.FormatInt(e.Size, 10), e.ModTime.Format(time.RFC3339), e.Mode, e.SHA256})
+	}
+	cw.Flush()
+	return cw.Error()
+}
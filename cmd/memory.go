@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"runtime/debug"
+)
+
+var maxMemoryFlag string
+
+// memoryJobs caps the goroutines reading ahead and compressing under
+// --max-memory, 0 without a cap.
+var memoryJobs int
+
+// gzipLevel is the compression level of archives. The fastest level is
+// used when memory is short, as its compressor needs a fraction of the
+// memory of the others.
+var gzipLevel = gzip.DefaultCompression
+
+// jobMemory is roughly what one job holds: a block of the archive stream
+// being compressed and its output, the compressor state, and files read
+// ahead.
+const jobMemory = 4 << 20
+
+// minMemory is the least --max-memory bak can run in.
+const minMemory = 32 << 20
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&maxMemoryFlag, "max-memory", "", "Keep the memory bak uses below this, e.g. 256M, by using fewer jobs, smaller buffers and upload chunks, and a lighter compressor")
+}
+
+// setMaxMemory fits the buffers and the number of jobs into --max-memory.
+// A quarter of it each goes to copy buffers, to jobs, and to upload chunks,
+// the rest is left to everything else. It has to run after the flags it
+// adjusts are parsed.
+func setMaxMemory() error {
+	if maxMemoryFlag == "" {
+		return nil
+	}
+	limit, err := parseSize(maxMemoryFlag)
+	if err != nil {
+		return fmt.Errorf("--max-memory: %w", err)
+	}
+	if limit < minMemory {
+		return fmt.Errorf("--max-memory: bak needs at least %s", formatSize(minMemory))
+	}
+	// The garbage collector works harder rather than go past the limit.
+	debug.SetMemoryLimit(limit)
+	share := limit / 4
+
+	// Several copies and hashes may hold a block at once.
+	if maxBlock := int(share/8) &^ (4<<10 - 1); blockSize > maxBlock {
+		printDebug("--max-memory: block size lowered to %s", formatSize(int64(maxBlock)))
+		blockSize = maxBlock
+	}
+
+	memoryJobs = max(int(share/jobMemory), 1)
+	if memoryJobs <= 2 {
+		gzipLevel = gzip.BestSpeed
+	}
+
+	if uploadChunkSize*int64(uploadConcurrency) > share {
+		uploadConcurrency = max(int(share/uploadChunkSize), 1)
+		uploadChunkSize = min(uploadChunkSize, share)
+		printDebug("--max-memory: uploading %d chunks of %s at once", uploadConcurrency, formatSize(uploadChunkSize))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+var keepAtime bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&keepAtime, "atime", false, "Also give plain .BAK copies, and files restored from them, the access time of the original")
+}
+
+// metadata is what a restored file is given besides its contents.
+type metadata struct {
+	owner *owner
+	mode  fs.FileMode
+	mtime time.Time
+	// atime is left as it is when zero.
+	atime  time.Time
+	xattrs map[string]string
+	// birthtime and flags are the creation time and file flags of macOS,
+	// left as they are when zero.
+	birthtime time.Time
+	flags     string
+}
+
+// setMetadata gives the restored file at target its metadata. Extended
+// attributes and ACLs that cannot be set, as most outside the user
+// namespace need root, are only warned about.
+func setMetadata(target string, m metadata) error {
+	target = longPath(target)
+	if err := m.owner.apply(target); err != nil {
+		return err
+	}
+	xattrs, acls := make(map[string]string), make(map[string]string)
+	for k, v := range m.xattrs {
+		switch {
+		case !keepXattr(k):
+		case isACLXattr(k):
+			acls[k] = v
+		default:
+			xattrs[k] = v
+		}
+	}
+	// Attributes go before the mode, which may take away the right to set
+	// them, ACLs after it, as a mode sets the mask of an ACL.
+	if err := writeXattrs(target, xattrs); err != nil {
+		printWarning("%s: extended attributes: %v", target, err)
+	}
+	if err := os.Chmod(target, chmodBits(m.mode)); err != nil {
+		return err
+	}
+	if err := writeXattrs(target, acls); err != nil {
+		printWarning("%s: ACLs: %v", target, err)
+	}
+	if err := os.Chtimes(target, m.atime, m.mtime); err != nil {
+		return err
+	}
+	// After the times, as an earlier modification time moves the creation
+	// time along, and the flags last, as they may make the file immutable.
+	if !m.birthtime.IsZero() {
+		if err := setBirthTime(target, m.birthtime); err != nil {
+			printWarning("%s: creation time: %v", target, err)
+		}
+	}
+	if m.flags != "" {
+		if err := setFileFlags(target, m.flags); err != nil {
+			printWarning("%s: file flags: %v", target, err)
+		}
+	}
+	return nil
+}
+
+// copyMetadata gives the plain copy at path the owner, mode, modification
+// and creation time of the file fi describes, its extended attributes
+// xattrs, and with --atime its access time. fi has to be taken before the
+// file is read, which may change its access time. File flags are left
+// out, an immutable copy could not be replaced by the next one.
+func copyMetadata(path string, fi fs.FileInfo, xattrs map[string]string) error {
+	m := metadata{owner: fileOwner(fi), mode: fi.Mode(), mtime: fi.ModTime(), xattrs: xattrs, birthtime: birthTime(fi)}
+	if keepAtime {
+		m.atime = accessTime(fi)
+	}
+	return setMetadata(path, m)
+}
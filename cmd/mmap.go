@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+	"strconv"
+)
+
+var useMmap bool
+
+// mmapMin is the smallest file that is mapped into memory rather than
+// read. Below it, mapping costs more than the reads it saves.
+const mmapMin = 64 << 20
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&useMmap, "mmap", true, "Map files over 64 MiB into memory instead of reading them; turn off on network filesystems")
+}
+
+// errMappedFault is a file that shrank while it was mapped.
+var errMappedFault = errors.New("file changed while it was read")
+
+// openContents returns a reader for the contents of f, a mapping of it for
+// large files, and a function that releases it.
+func openContents(f *os.File, fi os.FileInfo) (io.Reader, func()) {
+	// The address space of 32-bit systems is too small for large files.
+	if !useMmap || strconv.IntSize == 32 || fi.Size() < mmapMin || int64(int(fi.Size())) != fi.Size() {
+		return limitReads(f), func() {}
+	}
+	data, err := mapFile(f, int(fi.Size()))
+	if err != nil {
+		return limitReads(f), func() {}
+	}
+	return limitReads(&mappedReader{data: data}), func() { unmapFile(data) }
+}
+
+// mappedReader reads a file mapped into memory.
+type mappedReader struct {
+	data []byte
+	off  int
+}
+
+func (m *mappedReader) Read(p []byte) (n int, err error) {
+	if m.off >= len(m.data) {
+		return 0, io.EOF
+	}
+	// Touching the pages past the end of a file that was truncated raises
+	// SIGBUS, which this turns into an error.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			n, err = 0, errMappedFault
+		}
+	}()
+	n = copy(p, m.data[m.off:])
+	m.off += n
+	return n, nil
+}
@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data, err := unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	// The file is read once from start to end.
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+func unmapFile(data []byte) {
+	unix.Munmap(data)
+}
@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timestampNames  bool
+	timestampFormat string
+	versionScheme   string
+)
+
+// stamp is the time of the backup as put into output names, empty
+// without --timestamp.
+var stamp string
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&timestampNames, "timestamp", false, "Add the time of the backup to output names, like backup-2024-05-01T0230.tar, instead of overwriting the same file")
+	rootCmd.PersistentFlags().StringVar(&timestampFormat, "timestamp-format", "%Y-%m-%dT%H%M", "Format of the time added by --timestamp and --versions timestamp, in strftime notation")
+	rootCmd.PersistentFlags().StringVar(&versionScheme, "versions", "numbered", "How a single file backup that exists already is kept: numbered adds .1, .2, ..., timestamp adds the time, overwrite replaces it")
+}
+
+// setNames checks the naming flags and sets the time of the backup for
+// output names to now.
+func setNames(now time.Time) error {
+	switch versionScheme {
+	case "numbered", "timestamp", "overwrite":
+	default:
+		return fmt.Errorf("--versions: unknown scheme %q, use numbered, timestamp or overwrite", versionScheme)
+	}
+	if keepVersions < 0 {
+		return fmt.Errorf("--keep: %d is not a number of versions", keepVersions)
+	}
+
+	if !timestampNames && versionScheme != "timestamp" {
+		return nil
+	}
+	s, err := strftime(now, timestampFormat)
+	if err != nil {
+		return fmt.Errorf("--timestamp-format: %w", err)
+	}
+	if s == "" || strings.ContainsAny(s, `/\`) {
+		return fmt.Errorf("--timestamp-format: %q does not make a file name", s)
+	}
+	stamp = s
+	return nil
+}
+
+// stampTemplate adds the time of the backup to an archive path for
+// --timestamp, in front of the extensions of its file name.
+func stampTemplate(p string) string {
+	dir, base := filepath.Split(p)
+	if !timestampNames || base == "" {
+		return p
+	}
+	name, ext := base, ""
+	if i := strings.Index(base[1:], "."); i >= 0 {
+		name, ext = base[:i+1], base[i+1:]
+	}
+	return dir + name + "-{date}" + ext
+}
+
+// singleFileOutput returns the path the single file src is backed up to.
+// A backup of it that exists already is kept as --versions says.
+func singleFileOutput(src string) string {
+	base, ext := src+".BAK", ""
+	if timestampNames {
+		base = src + "-" + stamp + ".BAK"
+	}
+	if zipOutput {
+		ext = ".zip"
+	}
+
+	output := base + ext
+	_, err := os.Lstat(output)
+	if versionScheme == "numbered" {
+		// Numbering goes on after the unnumbered one was deleted by --keep.
+		if next := nextVersion(base, ext); err == nil || next != base+".1"+ext {
+			return next
+		}
+		return output
+	}
+	if err == nil && versionScheme == "timestamp" {
+		return base + "." + stamp + ext
+	}
+	return output
+}
+
+// singleFileVersions matches the names of the backups of the single file
+// src in its directory, whichever scheme made them.
+func singleFileVersions(src string) *regexp.Regexp {
+	stampRE := strftimeRegexp(timestampFormat)
+	re := "^" + regexp.QuoteMeta(filepath.Base(src))
+	if timestampNames {
+		re += "-" + stampRE
+	}
+	re += `\.BAK(\.\d+|\.` + stampRE + `)?(\.zip)?$`
+	return regexp.MustCompile(re)
+}
+
+// nextVersion numbers a backup one past the highest number that base has
+// been given so far.
+func nextVersion(base, ext string) string {
+	prefix := filepath.Base(base) + "."
+	last := 0
+	entries, _ := os.ReadDir(filepath.Dir(base))
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok {
+			continue
+		}
+		if rest, ok = strings.CutSuffix(rest, ext); !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(rest); err == nil && n > last {
+			last = n
+		}
+	}
+	return fmt.Sprintf("%s.%d%s", base, last+1, ext)
+}
+
+var templateVar = regexp.MustCompile(`\{([a-z-]+)(?::([^}]*))?\}`)
+
+// expandOutputs fills in the variables in the output paths:
+//
+//	{hostname}   the name of this machine, without its domain
+//	{basename}   the base name of the first source
+//	{date}       the time of the backup, {date:FORMAT} in strftime notation
+//	{seq}        the lowest number from 1 that makes a path not taken yet
+//	{git}        the commit checked out where the first source is
+//	{git-short}  the same, abbreviated
+//
+// With --timestamp, {date} is added to every path first.
+func expandOutputs(paths, sources []string, now time.Time) ([]string, error) {
+	var expanded []string
+	for _, p := range paths {
+		t := stampTemplate(p)
+		e, err := expandOutput(t, sources, now)
+		if err != nil {
+			return nil, fmt.Errorf("--path %s: %w", p, err)
+		}
+		if re := versionRegexp(t, sources); re != nil && !isRemoteOutput(e) {
+			outputVersions[e] = re
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// outputVersions holds for the archive outputs whose names change from run
+// to run a pattern that matches the names of the other runs.
+var outputVersions = map[string]*regexp.Regexp{}
+
+// versionRegexp returns a pattern for the file names the template p
+// gives in its directory on other runs. It is nil when the name is always
+// the same, or when the directory changes as well. It is nil too for a
+// name with no fixed text, as a pattern like ^\d+$ would take in files
+// bak never wrote.
+func versionRegexp(p string, sources []string) *regexp.Regexp {
+	dir, base := filepath.Split(p)
+	if templateVar.MatchString(dir) {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	varies := false
+	var fixed strings.Builder
+	last := 0
+	for _, m := range templateVar.FindAllStringSubmatchIndex(base, -1) {
+		b.WriteString(regexp.QuoteMeta(base[last:m[0]]))
+		fixed.WriteString(base[last:m[0]])
+		last = m[1]
+
+		name, arg := base[m[2]:m[3]], ""
+		if m[4] >= 0 {
+			arg = base[m[4]:m[5]]
+		}
+		switch name {
+		case "date":
+			if arg == "" {
+				arg = timestampFormat
+			}
+			b.WriteString(strftimeRegexp(arg))
+			varies = true
+		case "seq":
+			b.WriteString(`\d+`)
+			varies = true
+		case "git", "git-short":
+			b.WriteString(`[0-9a-f]+`)
+			varies = true
+		default:
+			v, err := templateValue(name, arg, sources, time.Time{}, 0)
+			if err != nil {
+				return nil
+			}
+			b.WriteString(regexp.QuoteMeta(v))
+			fixed.WriteString(v)
+		}
+	}
+	b.WriteString(regexp.QuoteMeta(base[last:]))
+	fixed.WriteString(base[last:])
+	b.WriteString("$")
+
+	if !varies {
+		return nil
+	}
+	if fixed.Len() == 0 {
+		if keepVersions > 0 {
+			printWarning("--keep: %s has nothing but variables in its name to tell its versions from other files by, keeping them all", p)
+		}
+		return nil
+	}
+	return regexp.MustCompile(b.String())
+}
+
+func expandOutput(p string, sources []string, now time.Time) (string, error) {
+	if !templateVar.MatchString(p) {
+		return p, nil
+	}
+
+	var err error
+	expand := func(seq int) string {
+		return templateVar.ReplaceAllStringFunc(p, func(m string) string {
+			sub := templateVar.FindStringSubmatch(m)
+			v, verr := templateValue(sub[1], sub[2], sources, now, seq)
+			if verr != nil && err == nil {
+				err = verr
+			}
+			return v
+		})
+	}
+
+	if !strings.Contains(p, "{seq}") {
+		e := expand(0)
+		return e, err
+	}
+	for seq := 1; ; seq++ {
+		e := expand(seq)
+		if err != nil || isRemoteOutput(e) {
+			return e, err
+		}
+		if _, serr := os.Lstat(e); serr != nil {
+			return e, nil
+		}
+	}
+}
+
+func templateValue(name, arg string, sources []string, now time.Time, seq int) (string, error) {
+	first := ""
+	if len(sources) > 0 {
+		first = sources[0]
+	}
+
+	switch name {
+	case "hostname":
+		host, err := os.Hostname()
+		host, _, _ = strings.Cut(host, ".")
+		return host, err
+	case "basename":
+		if isRemoteSource(first) {
+			_, dir, _ := strings.Cut(first, ":")
+			return path.Base(dir), nil
+		}
+		abs, err := filepath.Abs(first)
+		return filepath.Base(abs), err
+	case "date":
+		if arg == "" {
+			arg = timestampFormat
+		}
+		return strftime(now, arg)
+	case "seq":
+		return strconv.Itoa(seq), nil
+	case "git", "git-short":
+		return gitCommit(first, name == "git-short")
+	}
+	return "", fmt.Errorf("unknown variable {%s}", name)
+}
+
+// gitCommit returns the commit checked out in the repository holding src.
+func gitCommit(src string, short bool) (string, error) {
+	if isRemoteSource(src) {
+		return "", errors.New("no git commit for a remote source")
+	}
+	dir := src
+	if info, err := os.Stat(src); err == nil && !info.IsDir() {
+		dir = filepath.Dir(src)
+	}
+
+	args := []string{"-C", dir, "rev-parse"}
+	if short {
+		args = append(args, "--short")
+	}
+	args = append(args, "HEAD")
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git commit of %s: %s", src, msg)
+		}
+		return "", fmt.Errorf("git commit of %s: %w", src, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// strftimeRegexp returns a pattern matching the times strftime gives for
+// format.
+func strftimeRegexp(format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteString(regexp.QuoteMeta(format[i : i+1]))
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			b.WriteString(`\d{4}`)
+		case 'y', 'm', 'd', 'H', 'M', 'S':
+			b.WriteString(`\d{2}`)
+		case 'j':
+			b.WriteString(`\d{3}`)
+		case 'b', 'a':
+			b.WriteString(`[A-Za-z]{3}`)
+		case 'z':
+			b.WriteString(`[-+]\d{4}`)
+		case 'Z':
+			b.WriteString(`[-+0-9A-Za-z]+`)
+		case 's':
+			b.WriteString(`\d+`)
+		default:
+			b.WriteString(regexp.QuoteMeta(format[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// strftime formats t the way the C function does. Only the common
+// conversions are known, others are an error.
+func strftime(t time.Time, format string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("%q ends in a lone %%", format)
+		}
+		switch c := format[i]; c {
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'j':
+			b.WriteString(t.Format("002"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown conversion %%%c in %q", c, format)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,7 @@
+package cmd
+
+var honorNodump bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&honorNodump, "honor-nodump", false, "Skip files and directories carrying the nodump flag (chattr +d, chflags nodump)")
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// ufNodump is UF_NODUMP from sys/stat.h, the same on all BSDs.
+const ufNodump = 0x1
+
+// hasNodump reports whether file has the nodump flag set by chflags.
+func hasNodump(file string, info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Flags&ufNodump != 0
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsNodumpFl is FS_NODUMP_FL from linux/fs.h.
+const fsNodumpFl = 0x40
+
+// hasNodump reports whether file has the nodump attribute set by chattr +d.
+// The flags can only be read from regular files and directories.
+func hasNodump(file string, info fs.FileInfo) bool {
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return false
+	}
+
+	fd, err := unix.Open(file, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	return err == nil && flags&fsNodumpFl != 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package cmd
+
+import "io/fs"
+
+// hasNodump always reports false, the platform has no nodump flag.
+func hasNodump(file string, info fs.FileInfo) bool {
+	return false
+}
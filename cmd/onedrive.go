@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oneDriveAPI = "https://graph.microsoft.com/v1.0/me/drive/root:"
+
+	// Upload session fragments must be a multiple of 320 KiB and at most
+	// 60 MiB.
+	oneDriveChunkUnit = 320 << 10
+	oneDriveMaxChunk  = 60 << 20
+
+	// Files up to this size are sent in a single request.
+	oneDriveSimpleLimit = 4 << 20
+)
+
+// oneDriveUpload spools the archive to a temporary file and uploads it on
+// Close. Upload sessions need the total size up front, which a streamed
+// archive cannot provide.
+type oneDriveUpload struct {
+	path  string
+	token *oauthToken
+	spool *os.File
+	hash  hash.Hash
+}
+
+func newOneDriveUpload(path string) (*oneDriveUpload, error) {
+	token, err := oneDriveToken()
+	if err != nil {
+		return nil, err
+	}
+
+	spool, err := os.CreateTemp("", "bak-onedrive-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &oneDriveUpload{
+		path:  strings.TrimPrefix(path, "/"),
+		token: token,
+		spool: spool,
+		hash:  sha256.New(),
+	}, nil
+}
+
+func (u *oneDriveUpload) dest() string {
+	return "onedrive:/" + u.path
+}
+
+// oneDriveToken reads the credentials from the environment, preferring a
+// refresh token over a fixed access token.
+func oneDriveToken() (*oauthToken, error) {
+	if refresh := os.Getenv("ONEDRIVE_REFRESH_TOKEN"); refresh != "" {
+		form := url.Values{
+			"refresh_token": {refresh},
+			"client_id":     {os.Getenv("ONEDRIVE_CLIENT_ID")},
+			"scope":         {"Files.ReadWrite offline_access"},
+		}
+		if secret := os.Getenv("ONEDRIVE_CLIENT_SECRET"); secret != "" {
+			form.Set("client_secret", secret)
+		}
+		return &oauthToken{refresh: func() (string, time.Duration, error) {
+			return refreshToken("https://login.microsoftonline.com/common/oauth2/v2.0/token", form)
+		}}, nil
+	}
+
+	if access := os.Getenv("ONEDRIVE_ACCESS_TOKEN"); access != "" {
+		return &oauthToken{access: access}, nil
+	}
+
+	return nil, errors.New("onedrive: set ONEDRIVE_REFRESH_TOKEN and ONEDRIVE_CLIENT_ID, or ONEDRIVE_ACCESS_TOKEN")
+}
+
+func (u *oneDriveUpload) Write(p []byte) (int, error) {
+	n, err := u.spool.Write(p)
+	u.hash.Write(p[:n])
+	return n, err
+}
+
+// abort drops the spooled archive without uploading it.
+func (u *oneDriveUpload) abort() {
+	u.spool.Close()
+	os.Remove(u.spool.Name())
+}
+
+func (u *oneDriveUpload) Close() error {
+	defer os.Remove(u.spool.Name())
+	defer u.spool.Close()
+
+	size, err := u.spool.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := u.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if size <= oneDriveSimpleLimit {
+		err = u.uploadSimple()
+	} else {
+		err = u.uploadSession(size)
+	}
+	if err != nil {
+		return fmt.Errorf("onedrive: %w", err)
+	}
+	return nil
+}
+
+func (u *oneDriveUpload) uploadSimple() error {
+	data, err := io.ReadAll(u.spool)
+	if err != nil {
+		return err
+	}
+
+	_, err = doAuthorized(u.token, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, oneDriveAPI+oneDrivePath(u.path)+":/content", bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	return err
+}
+
+func (u *oneDriveUpload) uploadSession(size int64) error {
+	sum := hex.EncodeToString(u.hash.Sum(nil))
+	uploadURL, offset := u.resumeSession(size, sum)
+	if uploadURL == "" {
+		var err error
+		if uploadURL, err = u.createSession(); err != nil {
+			return err
+		}
+		saveUploadState(u.dest(), &uploadState{Session: uploadURL, Size: size, Hash: sum})
+	}
+	if _, err := u.spool.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// The upload URL is pre-authenticated and must not carry a bearer token.
+	chunkSize := min(max(uploadChunkSize/oneDriveChunkUnit, 1)*oneDriveChunkUnit, oneDriveMaxChunk)
+	buf := make([]byte, chunkSize)
+	for offset < size {
+		n, err := io.ReadFull(u.spool, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		chunk := buf[:n]
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size)
+		_, err = doRequest(func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(chunk))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Range", contentRange)
+			return req, nil
+		})
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+
+	clearUploadState(u.dest())
+	return nil
+}
+
+// resumeSession looks for an interrupted session that was uploading the same
+// data and asks the server how far it got. It returns an empty URL if there
+// is nothing to continue.
+func (u *oneDriveUpload) resumeSession(size int64, sum string) (string, int64) {
+	st := loadUploadState(u.dest())
+	if st == nil {
+		return "", 0
+	}
+	if st.Size != size || st.Hash != sum {
+		clearUploadState(u.dest())
+		return "", 0
+	}
+
+	body, err := doRequest(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, st.Session, nil)
+	})
+	if err != nil {
+		clearUploadState(u.dest())
+		return "", 0
+	}
+
+	var status struct {
+		NextExpectedRanges []string `json:"nextExpectedRanges"`
+	}
+	if json.Unmarshal(body, &status) != nil || len(status.NextExpectedRanges) == 0 {
+		clearUploadState(u.dest())
+		return "", 0
+	}
+	start, _, _ := strings.Cut(status.NextExpectedRanges[0], "-")
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		clearUploadState(u.dest())
+		return "", 0
+	}
+
+	printInfo("Resuming upload to %s at byte %d", u.dest(), offset)
+	return st.Session, offset
+}
+
+func (u *oneDriveUpload) createSession() (string, error) {
+	body, err := doAuthorized(u.token, func() (*http.Request, error) {
+		payload := `{"item":{"@microsoft.graph.conflictBehavior":"replace"}}`
+		req, err := http.NewRequest(http.MethodPost, oneDriveAPI+oneDrivePath(u.path)+":/createUploadSession", strings.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return "", err
+	}
+	return session.UploadURL, nil
+}
+
+// oneDrivePath escapes each segment of a drive path for use in a Graph URL.
+func oneDrivePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Archive data is handed to the destinations in batches of this size.
+const outputBatchSize = 256 << 10
+
+var errNoDestination = errors.New("no destination left to write to")
+
+// createOutput opens the destination for an archive. Plain paths are created
+// on the local disk, "dropbox:" and "onedrive:" paths are uploaded.
+func createOutput(dst string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasPrefix(dst, "dropbox:"):
+		return newDropboxUpload(strings.TrimPrefix(dst, "dropbox:"))
+	case strings.HasPrefix(dst, "onedrive:"):
+		return newOneDriveUpload(strings.TrimPrefix(dst, "onedrive:"))
+	default:
+		if err := checkClobber(dst); err != nil {
+			return nil, err
+		}
+		return createLocal(dst)
+	}
+}
+
+// localFile is an output on the local disk. It is written to a temporary
+// file next to its path, which only takes the final name on Close, so a
+// crash or a full disk never leaves a truncated file that looks complete.
+type localFile struct {
+	*os.File
+	path string
+}
+
+func createLocal(path string) (*localFile, error) {
+	f, err := os.CreateTemp(longPath(filepath.Dir(path)), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files only the owner can read, unlike os.Create,
+	// which leaves it to the umask.
+	if err := f.Chmod(0o666 &^ processUmask); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &localFile{File: f, path: path}, nil
+}
+
+// Close makes sure the data is on disk and moves it to its final name.
+func (f *localFile) Close() error {
+	err := f.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), longPath(f.path))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// abort drops the file without it ever taking its final name.
+func (f *localFile) abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// isRemoteOutput reports whether dst is uploaded rather than written to
+// the local disk.
+func isRemoteOutput(dst string) bool {
+	return strings.HasPrefix(dst, "dropbox:") || strings.HasPrefix(dst, "onedrive:")
+}
+
+// multiOutput writes a single archive stream to several destinations. Every
+// destination is fed by its own goroutine and can fall up to 16 batches
+// (4 MiB) behind, so short stalls of one do not hold up the others. A
+// destination that falls further behind, like a slow upload, slows the
+// whole backup down to its pace; that keeps the memory used bounded. A
+// destination that fails is dropped without affecting the others.
+type multiOutput struct {
+	dests   []*destination
+	pending []byte
+	alive   atomic.Int32
+	written int64
+}
+
+type destination struct {
+	path string
+	out  io.WriteCloser
+	ch   chan []byte
+	done chan struct{}
+	err  error
+}
+
+func createOutputs(paths []string) *multiOutput {
+	m := &multiOutput{}
+	for _, path := range paths {
+		d := &destination{path: path}
+		d.out, d.err = createOutput(path)
+		if d.err == nil {
+			d.ch = make(chan []byte, 16)
+			d.done = make(chan struct{})
+			m.alive.Add(1)
+			go d.run(&m.alive)
+		}
+		m.dests = append(m.dests, d)
+	}
+	return m
+}
+
+func (d *destination) run(alive *atomic.Int32) {
+	defer close(d.done)
+	for p := range d.ch {
+		if d.err != nil {
+			continue
+		}
+		if _, err := d.out.Write(p); err != nil {
+			d.err = err
+			alive.Add(-1)
+		}
+	}
+}
+
+func (m *multiOutput) Write(p []byte) (int, error) {
+	if m.alive.Load() == 0 {
+		return 0, errNoDestination
+	}
+
+	m.written += int64(len(p))
+	progressEvents.written(m.written, false)
+	m.pending = append(m.pending, p...)
+	if len(m.pending) >= outputBatchSize {
+		m.dispatch()
+	}
+	return len(p), nil
+}
+
+// dispatch hands the pending data to all open destinations. They only read
+// it, so one copy is shared between them.
+func (m *multiOutput) dispatch() {
+	if len(m.pending) == 0 {
+		return
+	}
+	for _, d := range m.dests {
+		if d.ch != nil {
+			d.ch <- m.pending
+		}
+	}
+	m.pending = nil
+}
+
+// finish flushes and closes every destination, reports the ones written
+// and returns the failures. err is a failure while producing the archive
+// itself.
+func (m *multiOutput) finish(what string, err error) error {
+	if interrupted.Load() {
+		return m.abort()
+	}
+
+	m.dispatch()
+	for _, d := range m.dests {
+		if d.ch == nil {
+			continue
+		}
+		close(d.ch)
+		<-d.done
+		if a, ok := d.out.(aborter); ok && (err != nil || d.err != nil) {
+			// An incomplete archive is not kept.
+			a.abort()
+		} else if cerr := d.out.Close(); d.err == nil {
+			d.err = cerr
+		}
+	}
+
+	var errs []error
+	if err != nil && !errors.Is(err, errNoDestination) {
+		// Writing the archive itself only fails on reading the sources,
+		// failing destinations are dropped.
+		errs = append(errs, withExitCode(exitSource, err))
+	}
+	progressEvents.written(m.written, true)
+	if err == nil {
+		result.BytesOut += m.written
+	}
+
+	failed := 0
+	for _, d := range m.dests {
+		if d.err != nil {
+			failed++
+		}
+	}
+	code := exitPartial
+	if failed == len(m.dests) {
+		code = exitDestination
+	}
+
+	for _, d := range m.dests {
+		derr := d.err
+		if derr == nil {
+			derr = err
+		}
+		result.addOutput(d.path, derr)
+		if derr != nil {
+			progressEvents.emit(progressEvent{Event: "output", Name: d.path, Message: derr.Error()})
+		} else {
+			progressEvents.emit(progressEvent{Event: "output", Name: d.path})
+		}
+
+		if d.err != nil {
+			errs = append(errs, withExitCode(code, fmt.Errorf("%s: %w", d.path, d.err)))
+		} else if err == nil {
+			printSuccess("%s backed up to %s", what, d.path)
+			pruneVersions(filepath.Dir(d.path), outputVersions[d.path])
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// abort drops the outputs of an interrupted backup. Local files are
+// removed, uploads are left unfinished so that the next run can resume
+// them.
+func (m *multiOutput) abort() error {
+	for _, d := range m.dests {
+		if d.ch != nil {
+			close(d.ch)
+			<-d.done
+			if a, ok := d.out.(aborter); ok {
+				a.abort()
+			}
+		}
+		result.addOutput(d.path, errInterrupted)
+		progressEvents.emit(progressEvent{Event: "output", Name: d.path, Message: errInterrupted.Error()})
+	}
+	return withExitCode(exitInterrupted, errInterrupted)
+}
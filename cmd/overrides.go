@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+var (
+	chownSpec string
+	chmodSpec string
+
+	// chownTo is the owner --chown gives restored files, with -1 for the
+	// id it leaves as archived.
+	chownTo *owner
+	// chmodOps are the changes --chmod makes to restored modes.
+	chmodOps []chmodOp
+)
+
+func init() {
+	restoreCmd.Flags().StringVar(&chownSpec, "chown", "", "Give restored files this owner instead of the archived one: user, user:group or :group, as names or ids")
+	restoreCmd.Flags().StringVar(&chmodSpec, "chmod", "", "Change the modes of restored files the way chmod does, e.g. 0644, go-w or u+rw,a+rX")
+}
+
+func setOverrides() error {
+	if chownSpec != "" {
+		u, g, _ := strings.Cut(chownSpec, ":")
+		o := &owner{-1, -1}
+		var err error
+		if u != "" {
+			if o.uid, err = parseID(u, func(name string) (string, error) {
+				u, err := user.Lookup(name)
+				if err != nil {
+					return "", err
+				}
+				return u.Uid, nil
+			}); err != nil {
+				return fmt.Errorf("--chown: %w", err)
+			}
+		}
+		if g != "" {
+			if o.gid, err = parseID(g, func(name string) (string, error) {
+				g, err := user.LookupGroup(name)
+				if err != nil {
+					return "", err
+				}
+				return g.Gid, nil
+			}); err != nil {
+				return fmt.Errorf("--chown: %w", err)
+			}
+		}
+		chownTo = o
+	}
+	if chmodSpec != "" {
+		ops, err := parseChmod(chmodSpec)
+		if err != nil {
+			return fmt.Errorf("--chmod: %w", err)
+		}
+		chmodOps = ops
+	}
+	return nil
+}
+
+// parseID returns the id s stands for, a number or a name lookup knows.
+func parseID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// overrideOwner returns the owner o with what --chown sets.
+func overrideOwner(o *owner) *owner {
+	if chownTo == nil {
+		return o
+	}
+	r := *chownTo
+	if o != nil {
+		if r.uid < 0 {
+			r.uid = o.uid
+		}
+		if r.gid < 0 {
+			r.gid = o.gid
+		}
+	}
+	return &r
+}
+
+// chmodOp is one change of a chmod mode: op ('+', '-' or '=') applied to
+// the bits in who, with perm from "rwxXst".
+type chmodOp struct {
+	who  uint32
+	op   byte
+	perm string
+	// set is the whole mode an octal --chmod gives.
+	set   uint32
+	octal bool
+}
+
+// The bits chmod's u, g and o stand for, in the usual octal notation.
+const (
+	whoUser  = 0o4700
+	whoGroup = 0o2070
+	whoOther = 0o1007
+)
+
+// parseChmod parses an octal mode or symbolic changes like "u+rw,go-w".
+func parseChmod(s string) ([]chmodOp, error) {
+	if n, err := strconv.ParseUint(s, 8, 32); err == nil {
+		if n > 0o7777 {
+			return nil, fmt.Errorf("mode %s out of range", s)
+		}
+		return []chmodOp{{set: uint32(n), octal: true}}, nil
+	}
+
+	var ops []chmodOp
+	for _, clause := range strings.Split(s, ",") {
+		var who uint32
+		i := 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= whoUser
+			case 'g':
+				who |= whoGroup
+			case 'o':
+				who |= whoOther
+			case 'a':
+				who |= whoUser | whoGroup | whoOther
+			}
+		}
+		if who == 0 {
+			who = whoUser | whoGroup | whoOther
+		}
+		if i == len(clause) {
+			return nil, fmt.Errorf("%q: missing +, - or =", clause)
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("%q: unexpected %q", clause, op)
+			}
+			j := i + 1
+			for ; j < len(clause) && strings.IndexByte("rwxXst", clause[j]) >= 0; j++ {
+			}
+			ops = append(ops, chmodOp{who: who, op: op, perm: clause[i+1 : j]})
+			i = j
+		}
+	}
+	return ops, nil
+}
+
+// applyChmod returns mode with the changes of --chmod.
+func applyChmod(mode fs.FileMode) fs.FileMode {
+	if chmodOps == nil {
+		return mode
+	}
+	bits := unixBits(mode)
+	for _, c := range chmodOps {
+		if c.octal {
+			bits = c.set
+			continue
+		}
+		var perm uint32
+		for _, p := range c.perm {
+			switch p {
+			case 'r':
+				perm |= 0o444
+			case 'w':
+				perm |= 0o222
+			case 'x':
+				perm |= 0o111
+			case 'X':
+				if mode.IsDir() || bits&0o111 != 0 {
+					perm |= 0o111
+				}
+			case 's':
+				perm |= 0o6000
+			case 't':
+				perm |= 0o1000
+			}
+		}
+		perm &= c.who
+		switch c.op {
+		case '+':
+			bits |= perm
+		case '-':
+			bits &^= perm
+		case '=':
+			bits = bits&^c.who | perm
+		}
+	}
+	return mode&^chmodBits(mode) | fileModeBits(bits)
+}
+
+// unixBits returns the chmod bits of mode in the usual octal notation.
+func unixBits(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+// fileModeBits turns octal chmod bits into a FileMode.
+func fileModeBits(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits) & fs.ModePerm
+	if bits&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
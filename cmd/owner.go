@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+var noSameOwner bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&noSameOwner, "no-same-owner", false, "Leave restored files and .BAK copies to the user running bak instead of giving them the owner and group of the original (which needs root)")
+}
+
+// owner is the user and group a restored file is given.
+type owner struct {
+	uid, gid int
+}
+
+// restoringOwners reports whether files get their original owners back,
+// which only root can do.
+func restoringOwners() bool {
+	return !noSameOwner && os.Geteuid() == 0
+}
+
+// apply gives the file at path the owner, not following symlinks. A nil
+// owner leaves it as it is.
+func (o *owner) apply(path string) error {
+	if o == nil {
+		return nil
+	}
+	return os.Lchown(path, o.uid, o.gid)
+}
+
+// headerOwner returns the owner the entry hdr is restored with, nil when
+// owners are not restored and --chown does not set one. The names win over
+// the numbers where they exist here, as the same user may have another id
+// on another system.
+func headerOwner(hdr *tar.Header) *owner {
+	if !restoringOwners() {
+		return overrideOwner(nil)
+	}
+	o := &owner{hdr.Uid, hdr.Gid}
+	if id, ok := lookupID(&userIDs, hdr.Uname, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}); ok {
+		o.uid = id
+	}
+	if id, ok := lookupID(&groupIDs, hdr.Gname, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	}); ok {
+		o.gid = id
+	}
+	return overrideOwner(o)
+}
+
+// userIDs and groupIDs cache the ids of names, -1 for names that do not
+// exist here.
+var userIDs, groupIDs sync.Map // map[string]int
+
+func lookupID(cache *sync.Map, name string, lookup func(string) (string, error)) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if id, ok := cache.Load(name); ok {
+		return id.(int), id.(int) >= 0
+	}
+	id := -1
+	if s, err := lookup(name); err == nil {
+		if n, err := strconv.Atoi(s); err == nil {
+			id = n
+		}
+	}
+	cache.Store(name, id)
+	return id, id >= 0
+}
+
+// fileOwner returns the owner of the file fi describes, nil when owners are
+// not restored or fi does not tell.
+func fileOwner(fi fs.FileInfo) *owner {
+	if !restoringOwners() {
+		return nil
+	}
+	if h, ok := fi.Sys().(*zip.FileHeader); ok {
+		return zipOwner(h.Extra)
+	}
+	return statOwner(fi)
+}
+
+// zipUnixExtra is the id of the extra field Info-ZIP stores the numeric
+// owner of a file in. Zip has no room for names.
+const zipUnixExtra = 0x7875
+
+// zipOwnerExtra returns the extra field holding uid and gid.
+func zipOwnerExtra(uid, gid int) []byte {
+	b := []byte{0, 0, 11, 0, 1, 4, 0, 0, 0, 0, 4, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(b, zipUnixExtra)
+	binary.LittleEndian.PutUint32(b[6:], uint32(uid))
+	binary.LittleEndian.PutUint32(b[11:], uint32(gid))
+	return b
+}
+
+// zipOwner reads the owner from the extra fields of a zip entry, nil when
+// it has none.
+func zipOwner(extra []byte) *owner {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+n {
+			return nil
+		}
+		field := extra[4 : 4+n]
+		extra = extra[4+n:]
+		if id != zipUnixExtra || len(field) < 2 || field[0] != 1 {
+			continue
+		}
+		uid, field, ok := zipID(field[1:])
+		if !ok || len(field) < 1 {
+			return nil
+		}
+		gid, _, ok := zipID(field)
+		if !ok {
+			return nil
+		}
+		return &owner{uid, gid}
+	}
+	return nil
+}
+
+// zipID reads an id prefixed by its size in bytes.
+func zipID(b []byte) (int, []byte, bool) {
+	n := int(b[0])
+	if n > 8 || len(b) < 1+n {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := n; i > 0; i-- {
+		id = id<<8 | uint64(b[i])
+	}
+	return int(id), b[1+n:], true
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cmd
+
+import "io/fs"
+
+// statOwner returns nil, files have no numeric owners here.
+func statOwner(fi fs.FileInfo) *owner {
+	return nil
+}
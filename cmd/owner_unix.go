@@ -0,0 +1,16 @@
+//go:build unix
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func statOwner(fi fs.FileInfo) *owner {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &owner{int(st.Uid), int(st.Gid)}
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	absolutePaths bool
+	fullPaths     bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&absolutePaths, "absolute-paths", "P", false, "Store sources under their absolute path, and restore entries with one there instead of below the current directory")
+	rootCmd.PersistentFlags().BoolVar(&fullPaths, "full-paths", false, "Store sources under the path they were given with instead of their base name, without a leading / or ..")
+}
+
+func setPaths() error {
+	if absolutePaths && fullPaths {
+		return fmt.Errorf("--absolute-paths cannot be used with --full-paths")
+	}
+	if absolutePaths || fullPaths {
+		keepPaths = true
+	}
+	return nil
+}
+
+// absoluteName returns the absolute path of the source path as an entry
+// name.
+func absoluteName(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.ToSlash(path)
+}
+
+// absoluteEntry reports whether the entry name, with OS separators, holds
+// an absolute path, and returns it relative to the root.
+func absoluteEntry(name string) (string, bool) {
+	rel := strings.TrimLeft(strings.TrimPrefix(name, filepath.VolumeName(name)), `/\`)
+	return rel, filepath.IsAbs(name) || rel != name
+}
+
+// restoresAbsolute reports whether the entry path is restored where it
+// says: with --absolute-paths, without restore --to, and without any "..".
+func restoresAbsolute(path string) bool {
+	return absolutePaths && restoreTo == "" && !slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..")
+}
+
+// reservedNames are the device names Windows keeps for itself in every
+// directory, with any extension.
+var reservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// portableName turns the entry name from an archive written on any system
+// into one that can be restored on this one, with slashes. Windows tools
+// may have stored backslashes, elsewhere they are only taken for
+// separators after a drive letter. A name only starts with a drive letter
+// when it looks like it came from Windows: X: followed by a separator, or
+// in a name with backslashes; a file called c:notes is just that. A drive
+// letter only means a drive on Windows, elsewhere it becomes a directory.
+// On Windows, names it reserves and characters it refuses are replaced.
+func portableName(name string, windows bool) string {
+	hasDrive := len(name) >= 2 && name[1] == ':' && isLetter(name[0]) &&
+		(len(name) > 2 && (name[2] == '/' || name[2] == '\\') || strings.Contains(name, `\`))
+	if windows || hasDrive {
+		name = strings.ReplaceAll(name, `\`, "/")
+	}
+	drive := ""
+	if hasDrive {
+		if windows {
+			drive, name = name[:2], name[2:]
+		} else {
+			name = name[:1] + "/" + strings.TrimLeft(name[2:], "/")
+		}
+	}
+	if !windows {
+		return name
+	}
+
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		p = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, p)
+		if p = strings.TrimRight(p, ". "); p == "" {
+			p = "_"
+		}
+		base, _, _ := strings.Cut(p, ".")
+		if slices.Contains(reservedNames, strings.ToUpper(strings.TrimRight(base, " "))) {
+			p = "_" + p
+		}
+		parts[i] = p
+	}
+	return drive + strings.Join(parts, "/")
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var interactive bool
+
+// picked holds the files chosen with --interactive, nil when every file
+// that passes the filters is archived.
+var picked map[string]bool
+
+// pickShown is how many entries the picker lists at once.
+const pickShown = 40
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&interactive, "interactive", "i", false, "Choose the files to archive from a searchable list before writing (local sources only)")
+}
+
+// collectFiles walks the local sources like a backup would and returns the
+// files that pass the filters and their total size.
+func collectFiles(sources []string) ([]string, int64, error) {
+	// The walk here must not count towards the summary of skipped files.
+	saved := sizeSkipped
+	defer func() { sizeSkipped = saved }()
+
+	var files []string
+	var total int64
+	for _, src := range sources {
+		if isRemoteSource(src) {
+			continue
+		}
+		ign, err := newIgnoreMatcher(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		err = walkTree(src, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if file == src {
+				if !fi.IsDir() {
+					files = append(files, file)
+					total += fi.Size()
+				}
+				return nil
+			}
+
+			rel, _ := filepath.Rel(src, file)
+			rel = filepath.ToSlash(rel)
+			e := walkEntry{rel: rel, file: file, depth: strings.Count(rel, "/") + 1, info: fi}
+			if selectEntry(e, ign) == selectSkip {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !fi.IsDir() {
+				files = append(files, file)
+				total += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return files, total, nil
+}
+
+// pickFiles lets the user tick the files to archive. It reads from the
+// terminal, so it also works when the sources came in on stdin.
+func pickFiles(sources []string) error {
+	files, _, err := collectFiles(sources)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		in = tty
+	}
+	scanner := bufio.NewScanner(in)
+
+	chosen := make(map[string]bool, len(files))
+	for _, f := range files {
+		chosen[f] = true
+	}
+
+	query := ""
+	for {
+		shown := fuzzyFilter(files, query)
+		fmt.Println()
+		for i, f := range shown[:min(len(shown), pickShown)] {
+			mark := " "
+			if chosen[f] {
+				mark = "x"
+			}
+			fmt.Printf("%4d [%s] %s\n", i+1, mark, f)
+		}
+		if len(shown) > pickShown {
+			fmt.Printf("     ... %d more, narrow the search to see them\n", len(shown)-pickShown)
+		}
+		fmt.Printf("%d of %d files selected", countChosen(chosen), len(files))
+		if query != "" {
+			fmt.Printf(", search %q", query)
+		}
+		fmt.Println()
+		fmt.Print("Numbers or ranges toggle, /text searches, a/n tick/untick all shown, Enter writes, q cancels: ")
+
+		if !scanner.Scan() {
+			return errors.New("no selection made")
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			picked = chosen
+			return nil
+		case line == "q":
+			return errors.New("cancelled")
+		case strings.HasPrefix(line, "/"):
+			query = line[1:]
+		case line == "a" || line == "n":
+			for _, f := range shown {
+				chosen[f] = line == "a"
+			}
+		default:
+			if err := toggleRanges(line, shown[:min(len(shown), pickShown)], chosen); err != nil {
+				fmt.Println("Error:", err)
+			}
+		}
+	}
+}
+
+// fuzzyFilter returns the files containing the characters of query in
+// order, ignoring case.
+func fuzzyFilter(files []string, query string) []string {
+	if query == "" {
+		return files
+	}
+	q := []rune(strings.ToLower(query))
+
+	var matched []string
+	for _, f := range files {
+		i := 0
+		for _, r := range strings.ToLower(f) {
+			if i < len(q) && r == q[i] {
+				i++
+			}
+		}
+		if i == len(q) {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// toggleRanges flips the entries given as "3 5-8" in the shown list.
+func toggleRanges(line string, shown []string, chosen map[string]bool) error {
+	for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+		lo, hi, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(lo)
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(hi)
+		}
+		if err != nil || first < 1 || last > len(shown) || first > last {
+			return fmt.Errorf("invalid selection %q", field)
+		}
+		for i := first; i <= last; i++ {
+			chosen[shown[i-1]] = !chosen[shown[i-1]]
+		}
+	}
+	return nil
+}
+
+func countChosen(chosen map[string]bool) int {
+	n := 0
+	for _, ok := range chosen {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,18 @@
+package cmd
+
+var lowPriority bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&lowPriority, "low-priority", false, "Run with the lowest CPU and disk priority, so the backup does not slow down other programs")
+}
+
+// setPriority lowers the priority of bak for --low-priority. Not getting
+// it is only worth a warning, the backup runs all the same.
+func setPriority() {
+	if !lowPriority {
+		return
+	}
+	if err := lowerPriority(); err != nil {
+		printWarning("--low-priority: %v", err)
+	}
+}
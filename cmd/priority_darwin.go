@@ -0,0 +1,16 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// The arguments of setpriority that put a process in the background, see
+// sys/resource.h.
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+// lowerPriority moves bak to the background QoS, where macOS throttles
+// both its CPU and its disk use, like taskpolicy -b.
+func lowerPriority() error {
+	return unix.Setpriority(prioDarwinProcess, 0, prioDarwinBG)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// The arguments of ioprio_set, see linux/ioprio.h.
+const (
+	ioprioWhoProcess  = 1
+	ioprioClassBE     = 2
+	ioprioClassShift  = 13
+	ioprioLowestLevel = 7
+)
+
+// lowerPriority sets the nice value to 19 and the I/O priority to the
+// lowest best-effort level, like nice -n 19 ionice -c2 -n7. On Linux both
+// belong to threads rather than processes, so every thread is lowered;
+// threads started later inherit it.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		// A thread may have ended since the directory was read.
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, 19); err != nil && !errors.Is(err, unix.ESRCH) {
+			return err
+		}
+		prio := ioprioClassBE<<ioprioClassShift | ioprioLowestLevel
+		if _, _, e := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); e != 0 && e != unix.ESRCH {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package cmd
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("not supported on this platform")
+}
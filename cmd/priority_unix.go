@@ -0,0 +1,11 @@
+//go:build unix && !darwin && !linux
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// lowerPriority sets the nice value to 19, which is all the priority there
+// is to lower here.
+func lowerPriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, 19)
+}
@@ -0,0 +1,8 @@
+package cmd
+
+import "golang.org/x/sys/windows"
+
+// lowerPriority puts bak in the below normal priority class.
+func lowerPriority() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.BELOW_NORMAL_PRIORITY_CLASS)
+}
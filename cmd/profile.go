@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var profileName string
+
+// configSources are the sources the config file names, backed up when
+// none are given.
+var configSources []string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Back up what this profile of the config file describes, its sources, destination, format, filters and retention; flags given as well win over it")
+}
+
+// mapValue returns the value of key in the mapping m, nil when it has none.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// findProfile returns the mapping of the profile name in the config root.
+func findProfile(root *yaml.Node, name, path string) (*yaml.Node, error) {
+	profiles := mapValue(root, "profiles")
+	if profiles == nil || profiles.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: no profile %q, the config has no profiles", path, name)
+	}
+	p := mapValue(profiles, name)
+	if p == nil {
+		var names []string
+		for i := 0; i < len(profiles.Content); i += 2 {
+			names = append(names, profiles.Content[i].Value)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("%s: no profile %q, there are %s", path, name, strings.Join(names, ", "))
+	}
+	if p.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: profile %q must be a mapping of flag names to values", path, p.Line, name)
+	}
+	return p, nil
+}
+
+// applySources takes the sources of the mapping m, unless sources were
+// found already.
+func applySources(m *yaml.Node, path string) error {
+	v := mapValue(m, "sources")
+	if v == nil || configSources != nil {
+		return nil
+	}
+	sources, err := configValues(v)
+	if err != nil {
+		return fmt.Errorf("%s:%d: sources: %w", path, v.Line, err)
+	}
+	configSources = sources
+	return nil
+}
+
+// configHasSources reports whether the config file names sources to back
+// up when none are given. Flags are not parsed from it yet.
+func configHasSources() bool {
+	root, _, err := readConfig()
+	return err == nil && root != nil && mapValue(root, "sources") != nil
+}
+
+// profileChain returns the profile name and those it extends, nearest
+// first, with their mappings. What a profile sets wins over the profile
+// it extends.
+func profileChain(root *yaml.Node, name, path string) ([]string, []*yaml.Node, error) {
+	var names []string
+	var profiles []*yaml.Node
+	for {
+		p, err := findProfile(root, name, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		profiles = append(profiles, p)
+
+		v := mapValue(p, "extends")
+		if v == nil {
+			return names, profiles, nil
+		}
+		if v.Kind != yaml.ScalarNode {
+			return nil, nil, fmt.Errorf("%s:%d: extends: expected the name of a profile", path, v.Line)
+		}
+		if slices.Contains(names, v.Value) {
+			return nil, nil, fmt.Errorf("%s:%d: profile %q extends itself: %s -> %s", path, v.Line, v.Value, strings.Join(names, " -> "), v.Value)
+		}
+		name = v.Value
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	progressMode string
+	progressFD   int
+	// progressFDFlag tells whether --progress-fd was given.
+	progressFDFlag *pflag.Flag
+)
+
+// progressInterval is the minimum time between two "bytes" events.
+const progressInterval = 200 * time.Millisecond
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", "", "Report progress while writing: bar (the default on a terminal), json for one event per line, or none")
+	rootCmd.PersistentFlags().IntVar(&progressFD, "progress-fd", 1, "File descriptor the progress events are written to, 2 with --json, which keeps stdout for the result")
+	progressFDFlag = rootCmd.PersistentFlags().Lookup("progress-fd")
+}
+
+// progressEvent is one line of the --progress=json stream.
+type progressEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// progressEvents is where progress events go, nil unless --progress=json.
+var progressEvents *eventStream
+
+type eventStream struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	last time.Time
+}
+
+// setProgress checks --progress and opens the stream for the events.
+func setProgress() error {
+	switch progressMode {
+	case "", "bar", "none":
+		return nil
+	case "json":
+	default:
+		return fmt.Errorf("--progress: unknown mode %q, use bar, json or none", progressMode)
+	}
+
+	fd := progressFD
+	if jsonOutput && fd == 1 {
+		// The events would get in the way of the result document.
+		if progressFDFlag.Changed {
+			return fmt.Errorf("--progress-fd 1: stdout is for the --json result, use another descriptor")
+		}
+		fd = 2
+	}
+
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = os.Stdout
+	case 2:
+		w = os.Stderr
+	default:
+		w = os.NewFile(uintptr(fd), "progress")
+		if w == (*os.File)(nil) {
+			return fmt.Errorf("--progress-fd: invalid descriptor %d", fd)
+		}
+	}
+	progressEvents = &eventStream{enc: json.NewEncoder(w)}
+	return nil
+}
+
+func (s *eventStream) emit(e progressEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.Time = time.Now()
+	s.enc.Encode(e)
+}
+
+// written reports the size of the archive so far, at most every
+// progressInterval unless final is set.
+func (s *eventStream) written(n int64, final bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	due := final || time.Since(s.last) >= progressInterval
+	if due {
+		s.last = time.Now()
+	}
+	s.mu.Unlock()
+
+	if due {
+		s.emit(progressEvent{Event: "bytes", Bytes: n})
+	}
+}
+
+// progressArchive sends an event before and after every file is written.
+type progressArchive struct {
+	archiveWriter
+}
+
+func (p progressArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag != tar.TypeReg {
+		return p.archiveWriter.writeEntry(hdr, r)
+	}
+
+	progressEvents.emit(progressEvent{Event: "file_start", Name: hdr.Name, Size: hdr.Size})
+	if err := p.archiveWriter.writeEntry(hdr, r); err != nil {
+		return err
+	}
+	progressEvents.emit(progressEvent{Event: "file_done", Name: hdr.Name, Size: hdr.Size})
+	return nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestProgressJSONWithJSONResult(t *testing.T) {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdoutW, stderrW
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = oldStdout, oldStderr
+		progressMode, jsonOutput, progressEvents = "", false, nil
+		progressFDFlag.Value.Set("1")
+		progressFDFlag.Changed = false
+	})
+	progressMode, jsonOutput = "json", true
+
+	// Without --progress-fd the events go to stderr, leaving stdout to
+	// the result.
+	if err := setProgress(); err != nil {
+		t.Fatal(err)
+	}
+	progressEvents.emit(progressEvent{Event: "start"})
+	stdoutW.Close()
+	stderrW.Close()
+	if out, _ := io.ReadAll(stdoutR); len(out) != 0 {
+		t.Errorf("events on stdout: %q", out)
+	}
+	if out, _ := io.ReadAll(stderrR); len(out) == 0 {
+		t.Error("no events on stderr")
+	}
+
+	// Asking for stdout is refused.
+	if err := rootCmd.PersistentFlags().Set("progress-fd", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProgress(); err == nil {
+		t.Error("--progress=json --progress-fd 1 --json accepted")
+	}
+}
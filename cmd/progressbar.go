@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	barWidth    = 30
+	barInterval = 100 * time.Millisecond
+)
+
+// bar is the progress bar shown on a terminal, nil when there is none.
+var bar *progressBar
+
+// progressBar draws the progress of a backup on one line of stderr. The
+// total comes from a scan of the local sources before writing, remote ones
+// only count once they are read.
+type progressBar struct {
+	mu      sync.Mutex
+	total   int64
+	done    int64
+	current string
+	start   time.Time
+	drawn   time.Time
+	shown   bool
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// wantProgressBar decides whether to draw a bar: always for --progress=bar,
+// and by default when stderr is a terminal and nothing else asks for quiet.
+func wantProgressBar() bool {
+	switch progressMode {
+	case "bar":
+		return true
+	case "":
+		return isTerminal(os.Stderr) && !quiet && !jsonOutput && !dryRun && !interactive
+	}
+	return false
+}
+
+func startProgressBar(sources []string) {
+	_, total, err := selectedSize(sources)
+	if err != nil {
+		total = 0
+	}
+	bar = &progressBar{total: total, start: time.Now()}
+}
+
+func (b *progressBar) setFile(name string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.current = name
+	b.mu.Unlock()
+	b.draw(false)
+}
+
+func (b *progressBar) add(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.done += n
+	b.mu.Unlock()
+	b.draw(false)
+}
+
+func (b *progressBar) draw(force bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(b.drawn) < barInterval {
+		return
+	}
+	b.drawn = now
+
+	elapsed := now.Sub(b.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(b.done) / elapsed
+	}
+
+	var line strings.Builder
+	if b.total > 0 {
+		frac := min(float64(b.done)/float64(b.total), 1)
+		filled := int(frac * barWidth)
+		fmt.Fprintf(&line, "[%s%s] %3.0f%% ", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), frac*100)
+		fmt.Fprintf(&line, "%s/%s", formatSize(b.done), formatSize(b.total))
+	} else {
+		line.WriteString(formatSize(b.done))
+	}
+	fmt.Fprintf(&line, " %s/s", formatSize(int64(rate)))
+	if b.total > b.done && rate > 0 {
+		eta := time.Duration(float64(b.total-b.done) / rate * float64(time.Second))
+		fmt.Fprintf(&line, " ETA %s", eta.Round(time.Second))
+	}
+	if b.current != "" {
+		line.WriteString(" " + b.current)
+	}
+
+	// \r returns to the start of the line and \x1b[K clears what is left
+	// of a longer previous one.
+	fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line.String())
+	b.shown = true
+}
+
+// clear removes the bar so a message can be printed, the next update draws
+// it again.
+func (b *progressBar) clear() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.shown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		b.shown = false
+		b.drawn = time.Time{}
+	}
+}
+
+// barArchive feeds the progress bar with the files written and the bytes
+// read from them.
+type barArchive struct {
+	archiveWriter
+}
+
+func (a barArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	bar.setFile(hdr.Name)
+	if r != nil {
+		r = &barReader{r}
+	}
+	return a.archiveWriter.writeEntry(hdr, r)
+}
+
+type barReader struct {
+	r io.Reader
+}
+
+func (br *barReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	bar.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	assumeYes bool
+	noInput   bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "Answer yes to confirmation prompts")
+	rootCmd.PersistentFlags().BoolVar(&noInput, "no-input", false, "Never wait for input, for scripts and cron jobs; prompts are answered yes")
+}
+
+// confirm shows msg and waits for Enter before going on. Nothing is asked
+// with --yes or --no-input, or when stdin is not a terminal, as there would
+// be nobody to answer.
+func confirm(msg string) {
+	if assumeYes || noInput || !isTerminal(os.Stdin) {
+		printDebug("%s Going on without asking.", msg)
+		return
+	}
+	fmt.Printf("Warning: %s Press 'Enter' to continue or 'Ctrl+C' to cancel.\n", msg)
+	fmt.Scanln()
+}
+
+// ask asks a yes or no question, no being the default. When nobody can
+// answer, --yes answers yes and anything else no.
+func ask(question string) bool {
+	if assumeYes {
+		return true
+	}
+	if noInput || !isTerminal(os.Stdin) {
+		return false
+	}
+
+	bar.clear()
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// checkInput refuses the options that need someone to answer when
+// --no-input is set.
+func checkInput() error {
+	if noInput && interactive {
+		return errors.New("--interactive needs input and cannot be used with --no-input")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var keepVersions int
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&keepVersions, "keep", 0, "After a backup, delete all but the newest N versions of its outputs (numbered or timestamped names, or in --store)")
+}
+
+// pruneVersions deletes all but the newest --keep files in dir whose
+// names match re. Without --keep nothing is deleted.
+func pruneVersions(dir string, re *regexp.Regexp) {
+	if keepVersions <= 0 || re == nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		printWarning("--keep: %v", err)
+		return
+	}
+
+	type version struct {
+		name string
+		info os.FileInfo
+	}
+	var versions []version
+	for _, e := range entries {
+		if !e.Type().IsRegular() && e.Type()&os.ModeSymlink == 0 || !re.MatchString(e.Name()) || strings.HasSuffix(e.Name(), indexSuffix) {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			versions = append(versions, version{e.Name(), info})
+		}
+	}
+	if len(versions) <= keepVersions {
+		return
+	}
+
+	// Newest first. The names break ties, as numbers and times in them sort
+	// along with the versions.
+	slices.SortFunc(versions, func(a, b version) int {
+		if c := b.info.ModTime().Compare(a.info.ModTime()); c != 0 {
+			return c
+		}
+		return compareNatural(b.name, a.name)
+	})
+	for _, v := range versions[keepVersions:] {
+		p := filepath.Join(dir, v.name)
+		if err := os.Remove(p); err != nil {
+			printWarning("--keep: %v", err)
+			continue
+		}
+		// The index of an archive goes with it.
+		os.Remove(p + indexSuffix)
+		printInfo("Removed old version %s", p)
+	}
+}
+
+// compareNatural compares names like cmp.Compare, but runs of digits by
+// their value, so that f.BAK.9 comes before f.BAK.10.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == "" || db == "" {
+			if a[0] != b[0] {
+				return cmp.Compare(a[0], b[0])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if c := cmp.Compare(len(na), len(nb)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(na, nb); c != 0 {
+			return c
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// anyName matches every file, for directories that hold nothing but
+// versions.
+var anyName = regexp.MustCompile(``)
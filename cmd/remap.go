@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	restoreTo string
+	mapSpecs  []string
+
+	// pathMaps are the --map rewrites, the longest old path first.
+	pathMaps []pathMap
+)
+
+// pathMap moves what is below old to below new.
+type pathMap struct {
+	old, new string
+}
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreTo, "to", "", "Restore below this directory instead of the current one, or for a file kept in --store instead of its original path; absolute entries go below it too")
+	restoreCmd.Flags().StringArrayVar(&mapSpecs, "map", nil, "Restore what was archived below OLD below NEW instead, as OLD=NEW, e.g. /var/www=/srv/www (repeatable)")
+}
+
+func setRemap() error {
+	for _, spec := range mapSpecs {
+		old, new, ok := strings.Cut(spec, "=")
+		old = strings.Trim(filepath.ToSlash(old), "/")
+		if !ok || old == "" {
+			return fmt.Errorf("--map: %q is not OLD=NEW", spec)
+		}
+		pathMaps = append(pathMaps, pathMap{old, strings.Trim(filepath.ToSlash(new), "/")})
+	}
+	slices.SortStableFunc(pathMaps, func(a, b pathMap) int {
+		return len(b.old) - len(a.old)
+	})
+	return nil
+}
+
+// mapName applies the first --map that matches to the slash-separated
+// entry name. Leading slashes do not take part in matching and are kept.
+func mapName(name string) string {
+	rel := strings.TrimLeft(name, "/")
+	lead := name[:len(name)-len(rel)]
+	for _, m := range pathMaps {
+		if rest, ok := strings.CutPrefix(rel, m.old); ok && (rest == "" || rest[0] == '/') {
+			return lead + strings.TrimLeft(m.new+rest, "/")
+		}
+	}
+	return name
+}
+
+// restoreRoot is the directory archives are extracted to.
+func restoreRoot() string {
+	if restoreTo != "" {
+		return restoreTo
+	}
+	return "."
+}
+
+// relocate returns where the file kept in the store for path is restored,
+// after --map and --to, and makes its directory.
+func relocate(path string) (string, error) {
+	if restoreTo == "" && len(pathMaps) == 0 {
+		return path, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	vol := filepath.VolumeName(abs)
+	target := vol + filepath.FromSlash(mapName(filepath.ToSlash(abs[len(vol):])))
+	if restoreTo != "" {
+		if target, err = storeEntry(restoreTo, target); err != nil {
+			return "", err
+		}
+	}
+	return target, os.MkdirAll(filepath.Dir(target), 0o755)
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var httpClient = &http.Client{}
+
+var (
+	uploadChunk       string
+	uploadChunkSize   int64
+	uploadConcurrency int
+	proxyURL          string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&uploadChunk, "upload-chunk-size", "8M", "Size of the chunks sent to remote destinations, each one is held in memory")
+	rootCmd.PersistentFlags().IntVar(&uploadConcurrency, "upload-concurrency", 1, "Number of chunks uploaded in parallel (Dropbox only, disables resuming)")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy for remote destinations, e.g. http://host:3128 or socks5://host:1080 (default from HTTPS_PROXY and NO_PROXY)")
+}
+
+func setRemoteOptions() error {
+	size, err := parseSize(uploadChunk)
+	if err != nil {
+		return fmt.Errorf("--upload-chunk-size: %w", err)
+	}
+	if size <= 0 {
+		return errors.New("--upload-chunk-size must be positive")
+	}
+	if uploadConcurrency < 1 {
+		return errors.New("--upload-concurrency must be at least 1")
+	}
+	uploadChunkSize = size
+
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("--proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("--proxy: unsupported scheme %q", u.Scheme)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(u)
+		httpClient.Transport = transport
+	}
+	return nil
+}
+
+// statusError is returned for responses outside the 2xx range.
+type statusError struct {
+	status     int
+	msg        string
+	body       []byte
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// oauthToken caches an access token. Tokens obtained through refresh are
+// renewed shortly before they expire or after the server rejects them.
+type oauthToken struct {
+	mu      sync.Mutex
+	access  string
+	expires time.Time
+	refresh func() (string, time.Duration, error)
+}
+
+func (t *oauthToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.access != "" && (t.refresh == nil || time.Now().Before(t.expires)) {
+		return t.access, nil
+	}
+	if t.refresh == nil {
+		return "", errors.New("access token rejected and no refresh token configured")
+	}
+
+	access, ttl, err := t.refresh()
+	if err != nil {
+		return "", err
+	}
+	t.access = access
+	t.expires = time.Now().Add(ttl - time.Minute)
+	return t.access, nil
+}
+
+func (t *oauthToken) invalidate() {
+	t.mu.Lock()
+	t.access = ""
+	t.mu.Unlock()
+}
+
+// refreshToken exchanges a refresh token at tokenURL. The form is updated in
+// place when the server rotates the refresh token.
+func refreshToken(tokenURL string, form url.Values) (string, time.Duration, error) {
+	form.Set("grant_type", "refresh_token")
+	body, err := doRequest(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, tokenURL, bytes.NewBufferString(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("refreshing access token: %w", err)
+	}
+
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", 0, fmt.Errorf("refreshing access token: %w", err)
+	}
+	if resp.RefreshToken != "" {
+		form.Set("refresh_token", resp.RefreshToken)
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+// doRequest sends the request built by newReq and returns the response body,
+// or a statusError if the server did not answer with a 2xx status. Transient
+// failures are retried, rebuilding the request for every attempt.
+func doRequest(newReq func() (*http.Request, error)) ([]byte, error) {
+	return withRetry(func() ([]byte, error) {
+		return sendRequest(newReq)
+	})
+}
+
+func sendRequest(newReq func() (*http.Request, error)) ([]byte, error) {
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = throttleReadCloser(req.Body, uploadLimit)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(throttleReadCloser(resp.Body, downloadLimit))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &statusError{
+			status:     resp.StatusCode,
+			msg:        fmt.Sprintf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, bytes.TrimSpace(body)),
+			body:       body,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return body, nil
+}
+
+// doAuthorized is doRequest with a bearer token. A rejected token is
+// refreshed once before giving up.
+func doAuthorized(token *oauthToken, newReq func() (*http.Request, error)) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		access, err := token.get()
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := doRequest(func() (*http.Request, error) {
+			req, err := newReq()
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+access)
+			return req, nil
+		})
+
+		var serr *statusError
+		if errors.As(err, &serr) && serr.status == http.StatusUnauthorized && token.refresh != nil && attempt == 0 {
+			token.invalidate()
+			continue
+		}
+		return body, err
+	}
+}
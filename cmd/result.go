@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var jsonOutput bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print the outcome as a single JSON document on stdout")
+}
+
+// backupResult is the outcome of a run, printed by --json.
+type backupResult struct {
+	Sources  []string       `json:"sources"`
+	Outputs  []outputResult `json:"outputs"`
+	Files    int            `json:"files"`
+	Skipped  int            `json:"skipped"`
+	BytesIn  int64          `json:"bytes_in"`
+	BytesOut int64          `json:"bytes_out"`
+	Duration float64        `json:"duration_seconds"`
+	Errors   []string       `json:"errors"`
+	// FileErrors are the files left out or stored incompletely with
+	// --keep-going.
+	FileErrors []fileError `json:"file_errors"`
+	// WouldWrite and Entries are what --dry-run would write.
+	WouldWrite []string      `json:"would_write,omitempty"`
+	Entries    []dryRunEntry `json:"entries,omitempty"`
+	Success    bool          `json:"success"`
+	ExitCode   int           `json:"exit_code"`
+}
+
+type outputResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+var result = backupResult{Outputs: []outputResult{}, Errors: []string{}, FileErrors: []fileError{}}
+
+func (r *backupResult) addOutput(path string, err error) {
+	o := outputResult{Path: path}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	r.Outputs = append(r.Outputs, o)
+}
+
+// printResult writes the result of a run that started at start to stdout.
+func printResult(sources []string, start time.Time) {
+	result.Sources = sources
+	result.Duration = time.Since(start).Seconds()
+	result.Success = len(result.Errors) == 0
+	result.ExitCode = exitStatus
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
+
+// printSummary tells what a run that started at start did. Runs that did
+// not get to write anything print no summary.
+func printSummary(start time.Time) {
+	if len(result.Outputs) == 0 {
+		return
+	}
+
+	ratio := ""
+	if result.BytesIn > 0 {
+		ratio = fmt.Sprintf(" (ratio %.2f)", float64(result.BytesOut)/float64(result.BytesIn))
+	}
+	printInfo("Summary: %d files archived, %d skipped, %d failed, %s read, %s written%s, %s, %d errors",
+		result.Files, result.Skipped, len(result.FileErrors), formatSize(result.BytesIn), formatSize(result.BytesOut), ratio,
+		time.Since(start).Round(time.Millisecond), len(result.Errors))
+}
+
+// countingArchive counts the files written to an archive and their size.
+type countingArchive struct {
+	archiveWriter
+}
+
+func (c countingArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag == tar.TypeReg {
+		result.Files++
+		result.BytesIn += hdr.Size
+	}
+	return c.archiveWriter.writeEntry(hdr, r)
+}
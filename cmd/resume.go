@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var resumeUploads bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&resumeUploads, "resume", true, "Resume interrupted uploads to remote destinations")
+}
+
+// uploadState records an unfinished upload session so that the next run
+// writing to the same destination can continue where the last one stopped.
+type uploadState struct {
+	// Session is the Dropbox session ID or the OneDrive upload URL.
+	Session string `json:"session"`
+	// Offset is the number of bytes the server has confirmed.
+	Offset int64 `json:"offset"`
+	// Size is the total size of the upload, if known in advance.
+	Size int64 `json:"size,omitempty"`
+	// Hash is the SHA-256 of the data the session was started for, used to
+	// make sure the new run produces the same bytes.
+	Hash string `json:"hash"`
+}
+
+func uploadStatePath(dest string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dest))
+	return filepath.Join(dir, "uploads", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// oldUploadStatePath is where versions of bak before the state directory
+// kept the state for dest: in the cache directory.
+func oldUploadStatePath(dest string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dest))
+	return filepath.Join(dir, "bak", "uploads", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadUploadState returns the saved state for dest, or nil if there is none
+// or resuming is disabled.
+func loadUploadState(dest string) *uploadState {
+	if !resumeUploads {
+		return nil
+	}
+
+	path, err := uploadStatePath(dest)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// An upload interrupted before the update can still be resumed.
+		if path, err = oldUploadStatePath(dest); err == nil {
+			data, err = os.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return nil
+	}
+
+	var st uploadState
+	if json.Unmarshal(data, &st) != nil || st.Session == "" {
+		return nil
+	}
+	return &st
+}
+
+// saveUploadState persists st for dest. Failing to do so only costs the
+// ability to resume, so it is reported but not fatal.
+func saveUploadState(dest string, st *uploadState) {
+	if !resumeUploads {
+		return
+	}
+
+	path, err := uploadStatePath(dest)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(st)
+		if err == nil {
+			err = os.WriteFile(path, data, 0o600)
+		}
+	}
+	if err != nil {
+		printWarning("saving upload state: %v", err)
+	}
+}
+
+func clearUploadState(dest string) {
+	if path, err := uploadStatePath(dest); err == nil {
+		os.Remove(path)
+	}
+	if path, err := oldUploadStatePath(dest); err == nil {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const maxRetryDelay = time.Minute
+
+var (
+	retries    int
+	retryDelay time.Duration
+)
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 5, "Number of times a failed request to a remote destination is retried")
+	rootCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", time.Second, "Initial delay between retries, doubled after every attempt")
+}
+
+// withRetry runs fn until it succeeds, fails permanently or runs out of
+// retries. Delays grow exponentially with jitter, unless the server asked
+// for a specific delay.
+func withRetry(fn func() ([]byte, error)) ([]byte, error) {
+	delay := max(retryDelay, 0)
+	for attempt := 0; ; attempt++ {
+		body, err := fn()
+		if err == nil || !isTransient(err) {
+			return body, err
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+
+		wait := delay/2 + rand.N(delay/2+1)
+		var serr *statusError
+		if errors.As(err, &serr) && serr.retryAfter > 0 {
+			wait = serr.retryAfter
+		}
+		printWarning("%v, retrying in %s", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// isTransient reports whether err is worth retrying: timeouts, dropped
+// connections, rate limiting and server-side errors.
+func isTransient(err error) bool {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		return serr.status == http.StatusTooManyRequests || serr.status >= 500
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// parseRetryAfter understands the delay-seconds form of Retry-After.
+func parseRetryAfter(s string) time.Duration {
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
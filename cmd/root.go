@@ -3,422 +3,698 @@ package cmd
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	outputPath   string
+	outputPaths  []string
 	zipOutput    bool
 	handleSingle bool
 	recursive    bool
+	bwLimit      string
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "bak [files or directories]",
 	Short: "A simple CLI tool for backing up files",
-	Args:  cobra.MinimumNArgs(1),
+	Long:  "A simple CLI tool for backing up files\n\n" + exitCodeHelp,
+	Args:  checkSources,
 	Run:   runBackup,
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&outputPath, "path", "p", "", "Specify the output path for the backup")
+	rootCmd.PersistentFlags().StringArrayVarP(&outputPaths, "path", "p", nil, "Specify the output path for the backup (dropbox:/path or onedrive:/path to upload), repeat to write to several destinations; {hostname}, {basename}, {date[:FORMAT]}, {seq}, {git} and {git-short} are filled in")
 	rootCmd.PersistentFlags().BoolVarP(&zipOutput, "zip", "z", false, "Compress the backup to a ZIP file")
 	rootCmd.PersistentFlags().BoolVarP(&handleSingle, "single", "s", false, "Handle multiple files as single files at the first level")
 	rootCmd.PersistentFlags().BoolVarP(&recursive, "recursive", "r", false, "Handle all files as single files recursively")
+	rootCmd.PersistentFlags().StringVar(&bwLimit, "bwlimit", "", "Limit upload bandwidth to remote destinations, e.g. 5M, or UP:DOWN to also limit downloads")
+}
+
+// checkSources requires at least one source, unless they come from a file
+// list or the config file.
+func checkSources(cmd *cobra.Command, args []string) error {
+	if hasFileLists() || profileName != "" || len(args) == 0 && configHasSources() {
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
 }
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
+	os.Exit(exitStatus)
 }
 
 func runBackup(cmd *cobra.Command, args []string) {
-	if recursive {
-		fmt.Println("Warning: Recursive backup may be heavy for many nested files. Press 'Enter' to continue or 'Ctrl+C' to cancel.")
-		fmt.Scanln()
+	start := time.Now()
+	sources, err := backup(args)
+	if err != nil {
+		reportError(err)
 	}
-
-	if len(args) == 1 {
-		handlePath(args[0])
-	} else {
-		backupMultipleFiles(args)
+	if err := writeErrorReport(); err != nil {
+		reportError(err)
 	}
-}
-
-func handlePath(path string) {
-	info, err := os.Stat(path)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if err := writeManifest(sources); err != nil {
+		reportError(err)
 	}
-
-	if info.IsDir() {
-		backupDirectory(path)
-	} else {
-		backupSingleFile(path)
+	if err := runPostHooks(start); err != nil {
+		reportError(withExitCode(exitHook, err))
 	}
-}
 
-func backupSingleFile(filePath string) {
-	output := filePath + ".BAK"
-	if zipOutput {
-		output += ".zip"
-		zipSingleFile(filePath, output)
+	if jsonOutput {
+		printResult(sources, start)
 	} else {
-		copyFile(filePath, output)
+		printSummary(start)
 	}
 }
 
-func backupDirectory(dirPath string) {
-	if outputPath == "" {
-		outputPath = "backup"
-		if zipOutput {
-			outputPath += ".zip"
-		} else {
-			outputPath += ".tar"
-		}
+// backup runs a backup of the sources in args and returns the sources it
+// worked on, which includes those read from file lists.
+func backup(args []string) ([]string, error) {
+	if err := setLogging(); err != nil {
+		return args, withExitCode(exitUsage, err)
 	}
-
-	if zipOutput {
-		zipDirectory(dirPath, outputPath)
-	} else {
-		tarDirectory(dirPath, outputPath)
+	if len(args) == 0 {
+		args = configSources
+	}
+	if len(args) == 0 && !hasFileLists() {
+		return args, withExitCode(exitUsage, errors.New("no sources given, nor in the config"))
+	}
+	if err := setBandwidthLimit(bwLimit); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setIOLimit(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setRemoteOptions(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setFilters(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setSymlinks(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setSpecialFiles(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setPaths(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setNormalize(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setProgress(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := checkInput(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := checkClobberFlags(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setManifest(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setJobs(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setBlockSize(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	if err := setMaxMemory(); err != nil {
+		return args, withExitCode(exitUsage, err)
+	}
+	setPriority()
+	stopDebug, err := startDebug()
+	defer stopDebug()
+	if err != nil {
+		return args, withExitCode(exitUsage, err)
 	}
-}
 
-func backupMultipleFiles(paths []string) {
-	if outputPath == "" {
-		outputPath = "backup"
-		if zipOutput {
-			outputPath += ".zip"
-		} else {
-			outputPath += ".tar"
+	// A source of "-" reads the list of sources from stdin, so bak can sit
+	// at the end of a pipeline.
+	if i := slices.Index(args, "-"); i >= 0 {
+		args = slices.Delete(args, i, i+1)
+		filesFrom = append(filesFrom, "-")
+	}
+	if hasFileLists() {
+		done := phase("file lists")
+		list, err := readFileLists()
+		done()
+		if err != nil {
+			return args, withExitCode(exitUsage, err)
 		}
+		args = append(args, list...)
+		keepPaths = true
 	}
 
-	if zipOutput {
-		zipMultipleFiles(paths, outputPath)
-	} else {
-		tarMultipleFiles(paths, outputPath)
+	now := time.Now()
+	if err := setNames(now); err != nil {
+		return args, withExitCode(exitUsage, err)
 	}
-}
-
-func copyFile(src, dst string) {
-	in, err := os.Open(src)
+	paths, err := expandOutputs(archiveOutputs(), args, now)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return args, withExitCode(exitUsage, err)
+	}
+	outputPaths = paths
+	if err := runPreHooks(); err != nil {
+		return args, withExitCode(exitHook, err)
 	}
-	defer in.Close()
 
-	out, err := os.Create(dst)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if interactive {
+		done := phase("pick")
+		err := pickFiles(args)
+		done()
+		if err != nil {
+			return args, err
+		}
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, in)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if err := checkTotalSize(args); err != nil {
+		return args, withExitCode(exitBudget, err)
+	}
+	if err := checkFreeSpace(args); err != nil {
+		return args, withExitCode(exitDestination, err)
 	}
 
-	fmt.Printf("File %s backed up to %s\n", src, dst)
-}
+	if wantProgressBar() {
+		startProgressBar(args)
+	}
 
-func zipSingleFile(src, dst string) {
-	outFile, err := os.Create(dst)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if recursive {
+		confirm("Recursive backup may be heavy for many nested files.")
 	}
-	defer outFile.Close()
 
-	zipWriter := zip.NewWriter(outFile)
-	defer zipWriter.Close()
+	defer handleSignals()()
 
-	inFile, err := os.Open(src)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	done := phase("backup")
+	if len(args) == 1 && !keepPaths {
+		err = handlePath(args[0])
+	} else {
+		err = backupMultipleFiles(args)
 	}
-	defer inFile.Close()
+	done()
+	reportSkipped()
+	return args, err
+}
 
-	w, err := zipWriter.Create(filepath.Base(src))
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+func handlePath(path string) error {
+	if isRemoteSource(path) {
+		return backupRemote(path)
 	}
 
-	_, err = io.Copy(w, inFile)
+	info, err := statArg(path)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return withExitCode(exitSource, err)
 	}
 
-	fmt.Printf("File %s backed up to %s\n", src, dst)
-}
-
-func tarDirectory(dirPath, dst string) {
-	outFile, err := os.Create(dst)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if info.IsDir() {
+		return backupDirectory(path)
 	}
-	defer outFile.Close()
-
-	gzWriter := gzip.NewWriter(outFile)
-	defer gzWriter.Close()
-
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
-
-	err = filepath.Walk(dirPath, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if isSymlink(info) && symlinkMode == symlinksSkip {
+		printInfo("Skipping symlink %s", path)
+		entrySkipped(path, "symlink")
+		return nil
+	}
+	if reason := skipSpecial(info); reason != "" {
+		if reason != skipSocket {
+			printInfo("Skipping %s, a %s (see --special-files)", path, reason)
 		}
+		entrySkipped(path, reason)
+		return nil
+	}
+	return backupSingleFile(path)
+}
 
-		header, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
+func backupSingleFile(filePath string) error {
+	output := singleFileOutput(filePath)
+	if storeDir != "" {
+		var err error
+		if output, err = storeOutput(filePath); err != nil {
 			return err
 		}
-
-		header.Name = strings.TrimPrefix(strings.Replace(file, dirPath, "", -1), string(filepath.Separator))
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
+	}
+	if dryRun {
+		planOutputs("file "+filePath, []string{output})
+		return nil
+	}
+	if storeDir != "" {
+		if err := os.MkdirAll(longPath(filepath.Dir(output)), 0o755); err != nil {
+			result.addOutput(output, err)
+			return withExitCode(exitDestination, err)
 		}
+	}
+	if err := checkClobber(output); err != nil {
+		result.addOutput(output, err)
+		return withExitCode(exitDestination, fmt.Errorf("%s: %w", output, err))
+	}
 
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
+	var err error
+	switch fi, serr := statArg(filePath); {
+	case zipOutput:
+		err = zipSingleFile(filePath, output)
+	case serr == nil && isSymlink(fi):
+		err = copyLink(filePath, output)
+	case serr == nil && isSpecial(fi):
+		err = copySpecial(filePath, output, fi)
+	default:
+		err = copyFile(filePath, output)
+	}
+	result.addOutput(output, err)
+	if err != nil {
+		return err
+	}
+	printSuccess("File %s backed up to %s", filePath, output)
+	if storeDir != "" {
+		pruneVersions(filepath.Dir(output), anyName)
+	} else {
+		pruneVersions(filepath.Dir(output), singleFileVersions(filePath))
+	}
+	return nil
+}
 
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+func backupDirectory(dirPath string) error {
+	return writeArchive("Directory "+dirPath, func(aw archiveWriter) error {
+		return addLocal(aw, dirPath, "")
+	})
+}
 
-		if _, err := io.Copy(tarWriter, f); err != nil {
-			return err
+func backupMultipleFiles(paths []string) error {
+	return writeArchive("Files", func(aw archiveWriter) error {
+		for _, path := range paths {
+			var err error
+			if isRemoteSource(path) {
+				err = addRemote(aw, path, true)
+			} else {
+				err = addLocal(aw, path, sourceName(path))
+			}
+			if err != nil {
+				return err
+			}
 		}
-
 		return nil
 	})
+}
 
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+func archiveOutputs() []string {
+	if len(outputPaths) > 0 {
+		return outputPaths
 	}
-
-	fmt.Printf("Directory %s backed up to %s\n", dirPath, dst)
+	if zipOutput {
+		return []string{"backup.zip"}
+	}
+	return []string{"backup.tar"}
 }
 
-func zipDirectory(dirPath, dst string) {
-	outFile, err := os.Create(dst)
+func copyFile(src, dst string) error {
+	in, err := os.Open(longPath(src))
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return withExitCode(exitSource, err)
 	}
-	defer outFile.Close()
-
-	zipWriter := zip.NewWriter(outFile)
-	defer zipWriter.Close()
-
-	err = filepath.Walk(dirPath, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	defer in.Close()
+	// Taken before reading, which may change the access time.
+	fi, err := in.Stat()
+	if err != nil {
+		return withExitCode(exitSource, err)
+	}
+	xattrs := fileXattrs(src)
 
-		header, err := zip.FileInfoHeader(fi)
-		if err != nil {
-			return err
-		}
+	out, err := createLocal(dst)
+	if err != nil {
+		return withExitCode(exitDestination, err)
+	}
 
-		header.Name = strings.TrimPrefix(strings.Replace(file, dirPath, "", -1), string(filepath.Separator))
-		if fi.IsDir() {
-			header.Name += "/"
+	// The manifest needs the contents hashed and --io-limit the reads
+	// paced, which rules out copying them in the kernel.
+	var n int64
+	var h hash.Hash
+	handled := false
+	if manifestFile == "" && readLimit == nil {
+		n, handled, err = fastCopy(out.File, in)
+	}
+	if !handled {
+		var src io.Reader = in
+		var dst io.Writer = out
+		var sw *sparseWriter
+		if data := sparseData(in, fi); data != nil {
+			// The copy gets the same holes.
+			src = &sizedReader{r: &sparseReader{f: in, data: data}, n: fi.Size()}
+			sw = &sparseWriter{f: out.File}
+			dst = sw
 		} else {
-			header.Method = zip.Deflate
+			var release func()
+			src, release = openContents(in, fi)
+			defer release()
 		}
-
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
-
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
-
-		f, err := os.Open(file)
-		if err != nil {
-			return err
+		var r io.Reader
+		r, h = manifestHash(interruptReader{src})
+		n, err = copyBlocks(dst, r)
+		if err == nil && sw != nil {
+			err = sw.finish()
 		}
-		defer f.Close()
-
-		_, err = io.Copy(writer, f)
-		return err
-	})
-
+	}
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		out.abort()
+		return interruptedError(err)
+	}
+	if err := out.Close(); err != nil {
+		return withExitCode(exitDestination, err)
 	}
+	if err := copyMetadata(dst, fi, xattrs); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	addManifest(filepath.Base(src), src, fi, h)
 
-	fmt.Printf("Directory %s backed up to %s\n", dirPath, dst)
+	result.Files++
+	result.BytesIn += n
+	result.BytesOut += n
+	return nil
 }
 
-func tarMultipleFiles(paths []string, dst string) {
-	outFile, err := os.Create(dst)
+func zipSingleFile(src, dst string) error {
+	if fi, err := statArg(src); err == nil && isSymlink(fi) {
+		return zipSymlink(src, dst, fi)
+	}
+	inFile, err := os.Open(longPath(src))
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return withExitCode(exitSource, err)
+	}
+	defer inFile.Close()
+	fi, err := inFile.Stat()
+	if err != nil {
+		return withExitCode(exitSource, err)
 	}
-	defer outFile.Close()
-
-	gzWriter := gzip.NewWriter(outFile)
-	defer gzWriter.Close()
 
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+	outFile, err := createLocal(dst)
+	if err != nil {
+		return withExitCode(exitDestination, err)
+	}
 
-	for _, path := range paths {
-		err := addFileToTar(tarWriter, path, "")
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
+	in, release := openContents(inFile, fi)
+	defer release()
+
+	var n, size int64
+	r, h := manifestHash(interruptReader{in})
+	zipWriter := newZipWriter(outFile)
+	// The entry keeps the mode and time restore gives the file back.
+	header, err := zip.FileInfoHeader(fi)
+	var w io.Writer
+	if err == nil {
+		header.Name = filepath.Base(src)
+		header.Method = zip.Deflate
+		if o := statOwner(fi); o != nil {
+			header.Extra = zipOwnerExtra(o.uid, o.gid)
 		}
+		w, err = zipWriter.CreateHeader(header)
+	}
+	if err == nil {
+		n, err = copyBlocks(w, r)
+	}
+	if xattrs := fileXattrs(src); err == nil && xattrs != nil {
+		err = writeZipXattrs(zipWriter, map[string]map[string]string{header.Name: xattrs})
+	}
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if err == nil {
+		size, err = outFile.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		outFile.abort()
+		return interruptedError(err)
 	}
+	if err := outFile.Close(); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	addManifest(filepath.Base(src), src, fi, h)
 
-	fmt.Printf("Files backed up to %s\n", dst)
+	result.Files++
+	result.BytesIn += n
+	result.BytesOut += size
+	return nil
 }
 
-func zipMultipleFiles(paths []string, dst string) {
-	outFile, err := os.Create(dst)
+// zipSymlink zips the symlink src to dst, its target as the contents the
+// way zip tools store links.
+func zipSymlink(src, dst string, fi os.FileInfo) error {
+	target, err := os.Readlink(longPath(src))
+	if err != nil {
+		return withExitCode(exitSource, err)
+	}
+	header, err := zip.FileInfoHeader(fi)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return withExitCode(exitSource, err)
 	}
-	defer outFile.Close()
+	header.Name = filepath.Base(src)
 
-	zipWriter := zip.NewWriter(outFile)
-	defer zipWriter.Close()
+	outFile, err := createLocal(dst)
+	if err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	zipWriter := newZipWriter(outFile)
+	w, err := zipWriter.CreateHeader(header)
+	if err == nil {
+		_, err = io.WriteString(w, target)
+	}
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if err != nil {
+		outFile.abort()
+		return withExitCode(exitDestination, err)
+	}
+	if err := outFile.Close(); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	result.Files++
+	return nil
+}
 
-	for _, path := range paths {
-		err := addFileToZip(zipWriter, path, "")
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
+// writeArchive creates the archive outputs and lets fill add the entries.
+func writeArchive(what string, fill func(aw archiveWriter) error) error {
+	dsts := archiveOutputs()
+	hardlinks.reset()
+	if dryRun {
+		planOutputs(what, dsts)
+		var aw archiveWriter = interruptArchive{listArchive{}}
+		if transformingNames() {
+			aw = renameArchive{aw}
 		}
+		return fill(newCaseArchive(aw))
 	}
 
-	fmt.Printf("Files backed up to %s\n", dst)
+	out := createOutputs(dsts)
+	if out.alive.Load() == 0 {
+		// Nothing to write to, not even worth reading the sources.
+		return out.finish(what, nil)
+	}
+	inner := newArchiveWriter(out)
+	var aw archiveWriter = interruptArchive{countingArchive{inner}}
+	if verbosity > 0 {
+		aw = verboseArchive{aw}
+	}
+	if progressEvents != nil {
+		aw = progressArchive{aw}
+	}
+	if bar != nil {
+		aw = barArchive{aw}
+	}
+	if transformingNames() {
+		aw = renameArchive{aw}
+	}
+	aw = newCaseArchive(aw)
+	err := fill(aw)
+	if err == nil {
+		err = aw.Close()
+	}
+	ferr := out.finish(what, err)
+	if t, ok := inner.(*tarArchive); ok && err == nil && t.index != nil {
+		out.writeIndex(t.index)
+	}
+	return ferr
 }
 
-func addFileToTar(tw *tar.Writer, path, baseDir string) error {
-	info, err := os.Stat(path)
-	if err != nil {
-		return err
+// sourceName returns the name a source given on its own is stored under,
+// its base name unless its path is kept or a manifest names it, "" for the
+// root. With --absolute-paths it is its absolute path.
+func sourceName(path string) string {
+	if name, ok := manifestNames[path]; ok {
+		return name
+	}
+	if absolutePaths {
+		return absoluteName(path)
 	}
+	name := filepath.Base(path)
+	if name == "." || name == ".." {
+		// The directory is stored under its own name, not as ./ or ../.
+		if abs, err := filepath.Abs(path); err == nil {
+			name = filepath.Base(abs)
+		}
+	}
+	if name == string(filepath.Separator) {
+		// The root has no name, its entries go at the top.
+		return ""
+	}
+	if keepPaths {
+		if dir := listedDir(path); dir != "" {
+			name = filepath.Join(dir, name)
+		}
+	}
+	return filepath.ToSlash(name)
+}
 
-	var base string
-	if baseDir == "" {
-		base = filepath.Base(path)
-	} else {
-		base = filepath.Join(baseDir, filepath.Base(path))
+// addLocal adds the local source src. With an empty name the entries of a
+// directory are stored at the top of the archive, otherwise the source is
+// stored as name with its entries below it.
+func addLocal(aw archiveWriter, src, name string) error {
+	info, err := statArg(src)
+	if err != nil {
+		return fileFailed(src, withExitCode(exitSource, err))
 	}
 
-	if info.IsDir() {
-		files, err := os.ReadDir(path)
-		if err != nil {
+	// A symlink given as the source itself is walked from where it points
+	// when symlinks are followed, so loops are told by the real paths.
+	root := src
+	if fi, err := os.Lstat(longPath(src)); err == nil && isSymlink(fi) && followSymlinks() {
+		if root, err = filepath.EvalSymlinks(src); err != nil {
 			return err
 		}
+	}
+	ign, err := newIgnoreMatcher(root)
+	if err != nil {
+		return err
+	}
 
-		for _, file := range files {
-			err := addFileToTar(tw, filepath.Join(path, file.Name()), base)
-			if err != nil {
-				return err
+	if name != "" {
+		switch sel, reason := classifyEntry(walkEntry{rel: name, file: src, info: info}, ign); sel {
+		case selectSkip:
+			entrySkipped(src, reason)
+			return nil
+		case selectKeep:
+			if err := writeLocal(aw, src, name, info); err != nil {
+				return fileFailed(src, err)
 			}
 		}
-	} else {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	}
+	if !info.IsDir() {
+		return nil
+	}
 
-		header, err := tar.FileInfoHeader(info, info.Name())
+	pf := newPrefetcher()
+	err = walkTree(root, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
+			// A directory that cannot be read is left out with everything
+			// below it.
+			return fileFailed(file, err)
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil || rel == "." {
 			return err
 		}
-		header.Name = base
+		rel = filepath.ToSlash(rel)
 
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+		entryName := rel
+		if name != "" {
+			entryName = name + "/" + rel
+		}
+		e := walkEntry{rel: entryName, file: file, depth: strings.Count(rel, "/") + 1, info: fi}
+		switch sel, reason := classifyEntry(e, ign); sel {
+		case selectSkip:
+			entrySkipped(file, reason)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case selectDescend:
+			return nil
 		}
+		prepare := linkLocal(file, entryName, fi)
+		return pf.add(fi.Size(), func() func() error {
+			write := prepare()
+			return func() error {
+				return fileFailed(file, write(aw))
+			}
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return pf.flush()
+}
 
-		_, err = io.Copy(tw, file)
-		if err != nil {
-			return err
+// localHeader returns the header for the file on disk stored as name.
+func localHeader(file, name string, fi os.FileInfo) (*tar.Header, error) {
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(longPath(file)); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	addXattrs(hdr, file)
+	addMacMetadata(hdr, fi)
+	return hdr, nil
 }
 
-func addFileToZip(zw *zip.Writer, path, baseDir string) error {
-	info, err := os.Stat(path)
+// writeLocal stores the file on disk as an entry called name.
+func writeLocal(aw archiveWriter, file, name string, fi os.FileInfo) error {
+	hdr, err := localHeader(file, name, fi)
 	if err != nil {
 		return err
 	}
 
-	var base string
-	if baseDir == "" {
-		base = filepath.Base(path)
-	} else {
-		base = filepath.Join(baseDir, filepath.Base(path))
-	}
-
-	if info.IsDir() {
-		files, err := os.ReadDir(path)
-		if err != nil {
+	if !fi.Mode().IsRegular() {
+		if err := aw.writeEntry(hdr, nil); err != nil {
 			return err
 		}
+		addManifest(name, file, fi, nil)
+		return nil
+	}
 
-		for _, file := range files {
-			err := addFileToZip(zw, filepath.Join(path, file.Name()), base)
-			if err != nil {
-				return err
-			}
+	f, err := os.Open(longPath(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Only the data of a sparse file is read, and only that is stored in
+	// a tar archive.
+	var src io.Reader
+	if data := sparseData(f, fi); data != nil {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string, 1)
 		}
+		hdr.PAXRecords[sparseMapKey] = formatSparseMap(data)
+		src = &sparseReader{f: f, data: data}
 	} else {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		w, err := zw.Create(base)
-		if err != nil {
-			return err
-		}
-
-		_, err = io.Copy(w, file)
-		if err != nil {
-			return err
-		}
+		var release func()
+		src, release = openContents(f, fi)
+		defer release()
 	}
-
-	return nil
+	r := &sizedReader{r: src, n: hdr.Size}
+	hr, h := manifestHash(r)
+	if err := aw.writeEntry(hdr, hr); err != nil {
+		return err
+	}
+	addManifest(name, file, fi, h)
+	return r.archived(file)
 }
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr string
+	apiAddr   string
+	apiToken  string
+	grpcAddr  string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [directory]",
+	Short: "Serve the backups in a directory over HTTP, read-only",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "localhost:8080", "Address to listen on")
+	serveCmd.Flags().StringVar(&apiAddr, "api", "", "Also expose the REST API under /api, listening on this address instead of --addr")
+	serveCmd.Flags().StringVar(&apiToken, "api-token", "", "Require this bearer token for the REST and gRPC APIs, needed unless they listen on a loopback address")
+	serveCmd.Flags().StringVar(&grpcAddr, "grpc", "", "Also serve the gRPC API, for controllers that run backups on many machines, on this address")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveFuncs = template.FuncMap{"size": formatSize}
+
+var indexTemplate = template.Must(template.New("index").Funcs(serveFuncs).Parse(`<!DOCTYPE html>
+<title>bak: {{.Dir}}</title>
+<h1>Backups in {{.Dir}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th><th></th></tr>
+{{range .Files}}<tr>
+<td><a href="/download/{{.Name}}">{{.Name}}</a></td>
+<td>{{size .Size}}</td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td>{{if .Archive}}<a href="/browse/{{.Name}}/">browse</a>{{end}}</td>
+</tr>{{end}}
+</table>
+`))
+
+var browseTemplate = template.Must(template.New("browse").Funcs(serveFuncs).Parse(`<!DOCTYPE html>
+<title>bak: {{.Name}}</title>
+<h1>{{.Name}}</h1>
+<p><a href="/">All backups</a> · <a href="/download/{{.Name}}">Download archive</a></p>
+<table>
+<tr><th>Entry</th><th>Size</th><th>Mode</th><th>Modified</th></tr>
+{{range .Entries}}<tr>
+<td>{{if .Mode.IsRegular}}<a href="/browse/{{$.Name}}/{{.Name}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+<td>{{if .Mode.IsRegular}}{{size .Size}}{{end}}</td>
+<td>{{.Mode}}</td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+</tr>{{end}}
+</table>
+`))
+
+type backupFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Archive bool
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		serveIndex(w, dir)
+	})
+	mux.HandleFunc("GET /download/{name}", func(w http.ResponseWriter, r *http.Request) {
+		serveDownload(w, r, dir, r.PathValue("name"))
+	})
+	mux.HandleFunc("GET /browse/{name}/{entry...}", func(w http.ResponseWriter, r *http.Request) {
+		if entry := r.PathValue("entry"); entry != "" {
+			serveEntry(w, dir, r.PathValue("name"), entry)
+		} else {
+			serveBrowse(w, dir, r.PathValue("name"))
+		}
+	})
+
+	addr := serveAddr
+	jobs := &jobManager{dir: dir, token: apiToken}
+	if apiAddr != "" {
+		if apiToken == "" && !isLoopback(apiAddr) {
+			reportError(withExitCode(exitUsage, errors.New("--api: starting backups is only open to everyone on a loopback address, set --api-token")))
+			return
+		}
+		jobs.register(mux)
+		addr = apiAddr
+	}
+	if grpcAddr != "" {
+		if apiToken == "" && !isLoopback(grpcAddr) {
+			reportError(withExitCode(exitUsage, errors.New("--grpc: starting backups is only open to everyone on a loopback address, set --api-token")))
+			return
+		}
+		l, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			printError(err)
+			return
+		}
+		printInfo("Serving the gRPC API on %s", grpcAddr)
+		go func() {
+			if err := serveGRPC(l, jobs); err != nil {
+				printError(err)
+			}
+		}()
+	}
+
+	printInfo("Serving backups in %s on http://%s", dir, addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		printError(err)
+	}
+}
+
+// listBackups returns the archives and .BAK copies in dir, newest first.
+func listBackups(dir string) ([]backupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backupFile
+	for _, e := range entries {
+		archive := isArchiveName(e.Name())
+		if !e.Type().IsRegular() || !archive && !strings.Contains(e.Name(), ".BAK") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, backupFile{
+			Name:    e.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Archive: archive,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	return files, nil
+}
+
+// backupPath resolves a file name from a URL inside dir, refusing anything
+// that is not a plain name.
+func backupPath(dir, name string) (string, bool) {
+	if name == "" || name != filepath.Base(name) || name == ".." || name == "." {
+		return "", false
+	}
+	return filepath.Join(dir, name), true
+}
+
+func serveIndex(w http.ResponseWriter, dir string) {
+	files, err := listBackups(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	indexTemplate.Execute(w, map[string]any{"Dir": dir, "Files": files})
+}
+
+func serveDownload(w http.ResponseWriter, r *http.Request, dir, name string) {
+	p, ok := backupPath(dir, name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func serveBrowse(w http.ResponseWriter, dir, name string) {
+	p, ok := backupPath(dir, name)
+	if !ok || !isArchiveName(name) {
+		http.Error(w, "not an archive", http.StatusNotFound)
+		return
+	}
+
+	entries, err := archiveEntries(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	browseTemplate.Execute(w, map[string]any{"Name": name, "Entries": entries})
+}
+
+// serveEntry streams a single file out of an archive.
+func serveEntry(w http.ResponseWriter, dir, name, entry string) {
+	p, ok := backupPath(dir, name)
+	if !ok || !isArchiveName(name) {
+		http.Error(w, "not an archive", http.StatusNotFound)
+		return
+	}
+
+	send := func(e archiveEntry, r io.Reader) {
+		contentType := mime.TypeByExtension(path.Ext(e.Name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+		io.Copy(w, r)
+	}
+
+	found, err := readIndexedEntry(p, entry, func(e archiveEntry, r io.Reader) error {
+		send(e, r)
+		return nil
+	})
+	if errors.Is(err, errNoIndex) {
+		// A hard link is read from the entry it points to, which comes
+		// before it, so that takes another walk.
+		for name, links := entry, 0; name != "" && links < 2; links++ {
+			link := ""
+			err = walkArchive(p, func(e archiveEntry, r io.Reader) error {
+				if e.Name != name || !e.Mode.IsRegular() {
+					return nil
+				}
+				if e.Link != "" {
+					link = e.Link
+				} else {
+					found = true
+					send(e, r)
+				}
+				return errStopWalk
+			})
+			name = link
+		}
+	}
+
+	if err != nil && !found {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "no such entry", http.StatusNotFound)
+	}
+}
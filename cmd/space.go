@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var spaceCheck bool
+
+// Room left on top of the estimated size, for archive headers and whatever
+// else is written to the disk meanwhile.
+const (
+	spaceMarginPercent = 5
+	spaceMarginBytes   = 1 << 20
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&spaceCheck, "space-check", false, "Check that local destinations have room for the backup before writing, which takes an extra walk over the sources")
+}
+
+// checkFreeSpace makes sure every local destination has room for the
+// uncompressed size of the local sources, so a full disk is found before
+// writing rather than in the middle of an archive.
+func checkFreeSpace(sources []string) error {
+	if !spaceCheck || dryRun {
+		return nil
+	}
+
+	var dsts []string
+	if len(sources) == 1 && !keepPaths && !isRemoteSource(sources[0]) {
+		if info, err := os.Stat(sources[0]); err == nil && !info.IsDir() {
+			// A single file is copied next to itself or into the store.
+			dsts = []string{sources[0]}
+			if storeDir != "" {
+				dsts = []string{filepath.Join(storeDir, "file")}
+			}
+		}
+	}
+	if dsts == nil {
+		dsts = archiveOutputs()
+	}
+
+	// Outputs in the same directory share its room.
+	outputs := map[string]int{}
+	for _, dst := range dsts {
+		if isRemoteOutput(dst) {
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Dir(dst))
+		if err != nil {
+			continue
+		}
+		outputs[dir]++
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	files, total, err := selectedSize(sources)
+	if err != nil {
+		return nil
+	}
+	// Every file takes a header and is padded to a whole block in a tar.
+	need := total + int64(files)*1024
+	need += need*spaceMarginPercent/100 + spaceMarginBytes
+
+	for dir, n := range outputs {
+		free, ok := freeSpace(dir)
+		if !ok || free >= need*int64(n) {
+			continue
+		}
+		return fmt.Errorf("%s has %s free, the backup needs about %s; leave out --space-check to try anyway",
+			dir, formatSize(free), formatSize(need*int64(n)))
+	}
+	return nil
+}
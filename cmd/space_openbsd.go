@@ -0,0 +1,13 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes an unprivileged user may still write to the
+// filesystem holding dir.
+func freeSpace(dir string) (int64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return st.F_bavail * int64(st.F_bsize), true
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !openbsd && !windows
+
+package cmd
+
+// freeSpace cannot tell the free space on this platform.
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
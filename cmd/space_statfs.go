@@ -0,0 +1,15 @@
+//go:build darwin || dragonfly || freebsd || linux
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes an unprivileged user may still write to the
+// filesystem holding dir.
+func freeSpace(dir string) (int64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
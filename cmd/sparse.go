@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sparseMapKey carries the data ranges of a sparse file in the header of its
+// entry, as "offset,length,offset,length...". archive/tar leaves GNU.sparse
+// records out of what it writes, tarArchive encodes the entry itself.
+const sparseMapKey = "GNU.sparse.map"
+
+// sparseRange is a range of a sparse file that holds data. The rest of the
+// file are holes that read as zeros without taking space on disk.
+type sparseRange struct {
+	off, n int64
+}
+
+// sparseData returns the data ranges of f when it has holes, nil when it
+// is stored in full.
+func sparseData(f *os.File, fi os.FileInfo) []sparseRange {
+	if !mayBeSparse(fi) {
+		return nil
+	}
+	data, err := dataRanges(f, fi.Size())
+	if err != nil {
+		return nil
+	}
+	var n int64
+	for _, d := range data {
+		n += d.n
+	}
+	if n == fi.Size() {
+		return nil
+	}
+	return data
+}
+
+func formatSparseMap(data []sparseRange) string {
+	var b strings.Builder
+	for i, d := range data {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d,%d", d.off, d.n)
+	}
+	return b.String()
+}
+
+func parseSparseMap(s string) ([]sparseRange, error) {
+	fields := strings.Split(s, ",")
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("odd number of fields in sparse map %q", s)
+	}
+	data := make([]sparseRange, len(fields)/2)
+	for i := range data {
+		off, err := strconv.ParseInt(fields[2*i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(fields[2*i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = sparseRange{off, n}
+	}
+	return data, nil
+}
+
+// sparseReader reads a sparse file, making up the zeros of its holes
+// instead of reading them.
+type sparseReader struct {
+	f    *os.File
+	data []sparseRange
+	off  int64
+	// at is the offset of f, which is behind off after a hole.
+	at int64
+}
+
+func (s *sparseReader) Read(p []byte) (int, error) {
+	for len(s.data) > 0 && s.off >= s.data[0].off+s.data[0].n {
+		s.data = s.data[1:]
+	}
+	if len(s.data) == 0 || s.off < s.data[0].off {
+		// In a hole, possibly the one at the end of the file, which ends
+		// wherever sizedReader stops reading.
+		if len(s.data) > 0 && int64(len(p)) > s.data[0].off-s.off {
+			p = p[:s.data[0].off-s.off]
+		}
+		clear(p)
+		s.off += int64(len(p))
+		return len(p), nil
+	}
+
+	if s.at != s.off {
+		if _, err := s.f.Seek(s.off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.at = s.off
+	}
+	if end := s.data[0].off + s.data[0].n; int64(len(p)) > end-s.off {
+		p = p[:end-s.off]
+	}
+	n, err := s.f.Read(p)
+	if readLimit != nil {
+		readLimit.wait(n)
+	}
+	s.off += int64(n)
+	s.at += int64(n)
+	return n, err
+}
+
+// writeSparse stores a sparse file in the PAX format 1.0 of GNU tar: the
+// entry holds a map of the data ranges followed by the data alone, under a
+// made-up name, and PAX records give its real name and size.
+func (a *tarArchive) writeSparse(hdr *tar.Header, data []sparseRange, r io.Reader) error {
+	if n := len(data); n == 0 || data[n-1].off+data[n-1].n < hdr.Size {
+		// A range of no data at the end makes extraction set the size of
+		// a file that ends in a hole.
+		data = append(data, sparseRange{hdr.Size, 0})
+	}
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "%d\n", len(data))
+	var stored int64
+	for _, d := range data {
+		fmt.Fprintf(&m, "%d\n%d\n", d.off, d.n)
+		stored += d.n
+	}
+	m.Write(make([]byte, blockPadding(int64(m.Len()))))
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+	}
+	for k, v := range hdr.PAXRecords {
+		if isMetadataRecord(k) {
+			records[k] = v
+		}
+	}
+	h := *hdr
+	h.Name = "GNUSparseFile.0/" + placeholderName(hdr.Name, 84)
+	h.Size = int64(m.Len()) + stored
+	h.PAXRecords = nil
+	h.ModTime = hdr.ModTime.Truncate(time.Second)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	h.Format = tar.FormatUSTAR
+	// Whatever does not fit the header goes into the records, a second
+	// set of PAX records would replace these.
+	if !fitsUSTAR(h.Uname, 32) {
+		records["uname"], h.Uname = h.Uname, ""
+	}
+	if !fitsUSTAR(h.Gname, 32) {
+		records["gname"], h.Gname = h.Gname, ""
+	}
+	if h.Uid >= 1<<21 {
+		records["uid"], h.Uid = strconv.Itoa(h.Uid), 0
+	}
+	if h.Gid >= 1<<21 {
+		records["gid"], h.Gid = strconv.Itoa(h.Gid), 0
+	}
+	if h.ModTime.Unix() < 0 || h.ModTime.Unix() >= 1<<33 {
+		records["mtime"] = strconv.FormatInt(h.ModTime.Unix(), 10)
+		h.ModTime = time.Unix(0, 0)
+	}
+
+	// The records go out as a raw block, archive/tar does not write them.
+	if err := a.tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := a.count.Write(paxHeader(h.Name, records)); err != nil {
+		return err
+	}
+	if err := a.tw.WriteHeader(&h); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(m.Bytes()); err != nil {
+		return err
+	}
+
+	var off int64
+	for _, d := range data {
+		if _, err := io.CopyN(io.Discard, r, d.off-off); err != nil {
+			return err
+		}
+		if _, err := copyBlocks(a.tw, io.LimitReader(r, d.n)); err != nil {
+			return err
+		}
+		off = d.off + d.n
+	}
+	return nil
+}
+
+// paxHeader returns an extended header holding records for the entry name,
+// padded to whole blocks. Extended attributes and the like go last, by
+// name.
+func paxHeader(name string, records map[string]string) []byte {
+	keys := []string{"GNU.sparse.major", "GNU.sparse.minor", "GNU.sparse.name", "GNU.sparse.realsize", "uname", "gname", "uid", "gid", "mtime"}
+	var xattrs []string
+	for k := range records {
+		if isMetadataRecord(k) {
+			xattrs = append(xattrs, k)
+		}
+	}
+	slices.Sort(xattrs)
+
+	var body bytes.Buffer
+	for _, k := range append(keys, xattrs...) {
+		v, ok := records[k]
+		if !ok {
+			continue
+		}
+		// The length at the start of a record counts its own digits.
+		rec := " " + k + "=" + v + "\n"
+		n := len(rec) + 1
+		for len(strconv.Itoa(n))+len(rec) != n {
+			n++
+		}
+		body.WriteString(strconv.Itoa(n) + rec)
+	}
+
+	block := make([]byte, 512)
+	copy(block[0:100], "PaxHeaders.0/"+placeholderName(name, 87))
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", body.Len()))
+	copy(block[136:148], "00000000000\x00")
+	block[156] = tar.TypeXHeader
+	copy(block[257:265], "ustar\x0000")
+	copy(block[148:156], "        ")
+	var sum int
+	for _, c := range block {
+		sum += int(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	out := append(block, body.Bytes()...)
+	return append(out, make([]byte, blockPadding(int64(body.Len())))...)
+}
+
+// blockPadding is how many bytes fill n up to whole tar blocks.
+func blockPadding(n int64) int64 {
+	return -n & 511
+}
+
+// placeholderName shortens the base name of name to at most max ASCII
+// characters, for the names a sparse entry is stored under. The real name
+// is in its records.
+func placeholderName(name string, max int) string {
+	b := []byte(path.Base(name))
+	for i, c := range b {
+		if c == 0 || c >= 0x80 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+func fitsUSTAR(s string, max int) bool {
+	if len(s) > max {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// sparseWriter writes a copy of a file, leaving holes where the data is
+// zeros, so a sparse file stays sparse.
+type sparseWriter struct {
+	f   *os.File
+	off int64
+	// end is how far the file was actually written.
+	end int64
+}
+
+// sparsePage is the size of the zero runs sparseWriter turns into holes.
+const sparsePage = 4096
+
+var zeroPage [sparsePage]byte
+
+func (s *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sparsePage)
+		if n == sparsePage && bytes.Equal(p[:n], zeroPage[:]) {
+			s.off += int64(n)
+		} else {
+			if _, err := s.f.WriteAt(p[:n], s.off); err != nil {
+				return written, err
+			}
+			s.off += int64(n)
+			s.end = s.off
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// finish gives the file its full size when it ends in a hole.
+func (s *sparseWriter) finish() error {
+	if s.off > s.end {
+		return s.f.Truncate(s.off)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !freebsd && !linux
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// mayBeSparse reports whether the file could have holes, which are not
+// looked for on this platform.
+func mayBeSparse(fi os.FileInfo) bool {
+	return false
+}
+
+func dataRanges(f *os.File, size int64) ([]sparseRange, error) {
+	return nil, errors.New("holes cannot be found on this platform")
+}
@@ -0,0 +1,46 @@
+//go:build darwin || freebsd || linux
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mayBeSparse reports whether the file takes less space on disk than its
+// size, which only a file with holes does.
+func mayBeSparse(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int64(st.Blocks)*512 < fi.Size()
+}
+
+// dataRanges finds the data in f with SEEK_DATA and SEEK_HOLE.
+func dataRanges(f *os.File, size int64) ([]sparseRange, error) {
+	defer f.Seek(0, io.SeekStart)
+	var data []sparseRange
+	var off int64
+	for off < size {
+		start, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// No data up to the end.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := f.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		if end > start {
+			data = append(data, sparseRange{start, end - start})
+		}
+		off = end
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+var specialFiles bool
+
+// skipSocket is why sockets are left out. They only exist while a program
+// listens on them, so there is nothing to store.
+const skipSocket = "socket"
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&specialFiles, "special-files", false, "Store FIFOs and device nodes as such in tar archives and .BAK copies instead of skipping them (sockets are always skipped)")
+}
+
+func setSpecialFiles() error {
+	if specialFiles && zipOutput {
+		return fmt.Errorf("--special-files: zip archives cannot hold FIFOs or device nodes")
+	}
+	return nil
+}
+
+// isSpecial reports whether fi is a FIFO, a device node or a socket, none
+// of which may be read like a file: a FIFO would block until something
+// writes to it.
+func isSpecial(fi fs.FileInfo) bool {
+	return fi.Mode().Type()&(fs.ModeNamedPipe|fs.ModeDevice|fs.ModeCharDevice|fs.ModeSocket) != 0
+}
+
+// skipSpecial returns why the special file fi is left out, or "" when it
+// is stored.
+func skipSpecial(fi fs.FileInfo) string {
+	switch {
+	case fi.Mode().Type()&fs.ModeSocket != 0:
+		return skipSocket
+	case isSpecial(fi) && !specialFiles:
+		return "special file"
+	}
+	return ""
+}
+
+// copySpecial makes dst a FIFO or device node like src.
+func copySpecial(src, dst string, fi fs.FileInfo) error {
+	os.Remove(longPath(dst))
+	if err := makeNode(dst, fi.Mode(), nodeDevice(fi)); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	if err := copyMetadata(dst, fi, nil); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	result.Files++
+	return nil
+}
+
+// headerNode makes path the FIFO or device node the tar entry hdr stores.
+func headerNode(path string, hdr *tar.Header) error {
+	mode := fs.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeFifo:
+		mode |= fs.ModeNamedPipe
+	case tar.TypeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	default:
+		mode |= fs.ModeDevice
+	}
+	os.Remove(path)
+	return makeNode(path, mode, makeDevice(hdr.Devmajor, hdr.Devminor))
+}
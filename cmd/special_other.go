@@ -0,0 +1,20 @@
+//go:build !darwin && !linux
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func makeNode(path string, mode fs.FileMode, dev uint64) error {
+	return errors.New("special files are not supported on this system")
+}
+
+func nodeDevice(fi fs.FileInfo) uint64 {
+	return 0
+}
+
+func makeDevice(major, minor int64) uint64 {
+	return 0
+}
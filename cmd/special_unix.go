@@ -0,0 +1,37 @@
+//go:build darwin || linux
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeNode creates the FIFO or device node path with the type and
+// permissions of mode. Device nodes need root.
+func makeNode(path string, mode fs.FileMode, dev uint64) error {
+	perm := uint32(mode.Perm())
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		perm |= unix.S_IFIFO
+	case mode&fs.ModeCharDevice != 0:
+		perm |= unix.S_IFCHR
+	default:
+		perm |= unix.S_IFBLK
+	}
+	return unix.Mknod(longPath(path), perm, int(dev))
+}
+
+// nodeDevice returns the device number of the device node fi.
+func nodeDevice(fi fs.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Rdev)
+	}
+	return 0
+}
+
+func makeDevice(major, minor int64) uint64 {
+	return unix.Mkdev(uint32(major), uint32(minor))
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var sshCommand string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&sshCommand, "ssh", "ssh", "Command used to reach remote sources given as [user@]host:path")
+}
+
+// remoteSourcePattern matches [user@]host:path. Neither user nor host may
+// start with "-", which ssh would take for an option.
+var remoteSourcePattern = regexp.MustCompile(`^(?:[^-@/:][^@/:]*@)?[^-@/:][^@/:]+:(.*)$`)
+
+// isRemoteSource reports whether arg names a path on another machine. Local
+// paths that happen to contain a colon win if they exist.
+func isRemoteSource(arg string) bool {
+	if !remoteSourcePattern.MatchString(arg) {
+		return false
+	}
+	_, err := os.Lstat(arg)
+	return err != nil
+}
+
+// readRemote runs tar on the remote host and passes every entry to add. Like
+// local sources, a directory's entries are named relative to it, unless
+// keepName is set, which puts them below the directory's own name.
+func readRemote(src string, keepName bool, add func(hdr *tar.Header, r io.Reader) error) error {
+	host, dir, _ := strings.Cut(src, ":")
+	if dir == "" {
+		dir = "."
+	}
+
+	q := shellQuote(dir)
+	script := fmt.Sprintf(`cd "$(dirname %s)" && exec tar cf - "$(basename %s)"`, q, q)
+	if !keepName {
+		script = fmt.Sprintf(`if [ -d %s ]; then cd %s && exec tar cf - .; else %s; fi`, q, q, script)
+	}
+
+	args := strings.Fields(sshCommand)
+	if len(args) == 0 {
+		return fmt.Errorf("--ssh must not be empty")
+	}
+	// "--" ends the options, whatever host looks like.
+	cmd := exec.Command(args[0], append(args[1:], "--", host, script)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var skipped []string
+	tr := tar.NewReader(stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("%s: %w", src, remoteError(err, &stderr))
+		}
+
+		hdr.Name = strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if hdr.Name == "." || underAny(hdr.Name, skipped) {
+			continue
+		}
+		depth := strings.Count(hdr.Name, "/") + 1
+		base := dir
+		if keepName {
+			depth--
+			base = path.Dir(dir)
+		}
+		switch sel, reason := classifyEntry(walkEntry{rel: hdr.Name, depth: depth, info: hdr.FileInfo()}, nil); sel {
+		case selectSkip:
+			entrySkipped(host+":"+path.Join(base, hdr.Name), reason)
+			if hdr.Typeflag == tar.TypeDir {
+				skipped = append(skipped, hdr.Name)
+			}
+			continue
+		case selectDescend:
+			continue
+		}
+		r, h := manifestHash(tr)
+		if err := add(hdr, r); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+		addManifest(hdr.Name, host+":"+path.Join(base, hdr.Name), hdr.FileInfo(), h)
+	}
+
+	// Drain the end of the stream so tar can exit cleanly.
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w", src, remoteError(err, &stderr))
+	}
+	return nil
+}
+
+// underAny reports whether name lies inside one of the directories dirs.
+func underAny(name string, dirs []string) bool {
+	for _, d := range dirs {
+		if strings.HasPrefix(name, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func backupRemote(src string) error {
+	return writeArchive("Remote "+src, func(aw archiveWriter) error {
+		return addRemote(aw, src, false)
+	})
+}
+
+// addRemote copies the entries of a remote source into the archive.
+func addRemote(aw archiveWriter, src string, keepName bool) error {
+	return readRemote(src, keepName, aw.writeEntry)
+}
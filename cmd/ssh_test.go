@@ -0,0 +1,17 @@
+package cmd
+
+import "testing"
+
+func TestRemoteSourceDashHost(t *testing.T) {
+	for arg, want := range map[string]bool{
+		"host:dir":                            true,
+		"user@host:dir":                       true,
+		"-oProxyCommand=touch pwned:x":        false,
+		"user@-oProxyCommand=touch pwned:x":   false,
+		"-oProxyCommand=touch pwned@host:dir": false,
+	} {
+		if got := isRemoteSource(arg); got != want {
+			t.Errorf("isRemoteSource(%q) = %v, want %v", arg, got, want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var storeDir string
+
+var (
+	restoreList    bool
+	restoreVersion string
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <original path | archive>",
+	Short: "Restore a file from the backups kept in --store, or extract an archive",
+	Long: `Restore a file from the backups kept in --store, or extract an archive.
+
+Given a tar, tar.gz or zip archive, restore extracts its entries into the
+current directory, writing up to --jobs files at once. Anything else is the
+original path of a file kept in --store.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runRestore,
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&storeDir, "store", "", "Keep single file backups in this directory, by original path and time, instead of next to the file")
+	restoreCmd.Flags().BoolVar(&restoreList, "list", false, "List the stored versions instead of restoring")
+	restoreCmd.Flags().StringVar(&restoreVersion, "version", "", "Restore this version instead of the newest")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+// storeLayout is the time format of the versions in the store, which sorts
+// the same as the times themselves.
+const storeLayout = "%Y%m%dT%H%M%S"
+
+// defaultStoreDir is where restore looks without --store.
+func defaultStoreDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil || os.Getenv("XDG_DATA_HOME") != "" {
+		return dir, err
+	}
+	// Before dataDir, the store was in ~/.local/share/bak on every system.
+	// Keep looking there as long as there is no store in the new place.
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return dir, nil
+	}
+	old := filepath.Join(home, ".local", "share", "bak")
+	if _, err := os.Stat(dir); err != nil {
+		if fi, err := os.Stat(old); err == nil && fi.IsDir() {
+			return old, nil
+		}
+	}
+	return dir, nil
+}
+
+// storeEntry returns the directory in the store that keeps the versions
+// of the file at path.
+func storeEntry(store, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	// A Windows volume becomes a directory of its own: C:\x is kept as C\x.
+	vol := filepath.VolumeName(abs)
+	key := strings.TrimLeft(strings.TrimSuffix(vol, ":")+abs[len(vol):], `/\`)
+	return filepath.Join(store, key), nil
+}
+
+// storeOutput returns the path in the store that a backup of src taken
+// now is written to.
+func storeOutput(src string) (string, error) {
+	dir, err := storeEntry(storeDir, src)
+	if err != nil {
+		return "", err
+	}
+	name, err := strftime(time.Now(), storeLayout)
+	if err != nil {
+		return "", err
+	}
+	ext := ""
+	if zipOutput {
+		ext = ".zip"
+	}
+
+	output := filepath.Join(dir, name+ext)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(output); err != nil {
+			return output, nil
+		}
+		output = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, n, ext))
+	}
+}
+
+func runRestore(cmd *cobra.Command, args []string) {
+	if err := setLogging(); err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	if err := setNormalize(); err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	if err := setOverrides(); err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	if err := setOnConflict(); err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	if err := setRemap(); err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	setUmask()
+	var err error
+	// A file with versions in the store is restored from there, even when
+	// it is an archive itself, like a .docx or .jar.
+	if !inStore(args[0]) && isArchiveFile(args[0]) && !restoreList && restoreVersion == "" {
+		defer handleSignals()()
+		err = extractArchive(args[0], restoreRoot())
+	} else {
+		err = restore(args[0])
+	}
+	if err != nil {
+		reportError(err)
+	}
+}
+
+// inStore reports whether the store keeps versions of the file at path.
+func inStore(path string) bool {
+	store := storeDir
+	if store == "" {
+		var err error
+		if store, err = defaultStoreDir(); err != nil {
+			return false
+		}
+	}
+	dir, err := storeEntry(store, path)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+// restore puts a stored version of the file at path back in its place.
+func restore(path string) error {
+	store := storeDir
+	if store == "" {
+		var err error
+		if store, err = defaultStoreDir(); err != nil {
+			return err
+		}
+	}
+	dir, err := storeEntry(store, path)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return withExitCode(exitSource, fmt.Errorf("no backups of %s in %s", path, store))
+	}
+	if err != nil {
+		return withExitCode(exitSource, err)
+	}
+	var versions []string
+	for _, e := range entries {
+		// A version of a symlink is a symlink.
+		if e.Type().IsRegular() || e.Type()&fs.ModeSymlink != 0 {
+			versions = append(versions, e.Name())
+		}
+	}
+	slices.Sort(versions)
+
+	if restoreList {
+		for _, v := range versions {
+			fmt.Println(v)
+		}
+		return nil
+	}
+
+	if len(versions) == 0 {
+		return withExitCode(exitSource, fmt.Errorf("no backups of %s in %s", path, store))
+	}
+	version := versions[len(versions)-1]
+	if restoreVersion != "" {
+		i := slices.IndexFunc(versions, func(v string) bool {
+			return v == restoreVersion || strings.TrimSuffix(v, ".zip") == restoreVersion
+		})
+		if i < 0 {
+			return withExitCode(exitSource, fmt.Errorf("no version %s of %s, see restore --list", restoreVersion, path))
+		}
+		version = versions[i]
+	}
+
+	src := filepath.Join(dir, version)
+	var mtime time.Time
+	if fi, err := os.Stat(src); err == nil {
+		mtime = fi.ModTime()
+	}
+	target, err := relocate(path)
+	if err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	if target, err = resolveConflict(target, mtime); err != nil {
+		return withExitCode(exitDestination, fmt.Errorf("%s: %w", path, err))
+	}
+	if target == "" {
+		printInfo("File %s left as it is", path)
+		return nil
+	}
+	if err := restoreVersionTo(src, target); err != nil {
+		return err
+	}
+	printSuccess("File %s restored from %s", target, version)
+	return nil
+}
+
+// restoreVersionTo copies the stored version src to dst. A zipped version
+// holds the file as its only entry, besides its extended attributes.
+func restoreVersionTo(src, dst string) error {
+	var r io.Reader
+	var fi fs.FileInfo
+	var xattrs map[string]string
+	if strings.HasSuffix(src, ".zip") {
+		zr, err := zip.OpenReader(src)
+		if err != nil {
+			return withExitCode(exitSource, err)
+		}
+		defer zr.Close()
+		var file *zip.File
+		var stored map[string]map[string]string
+		n := 0
+		for _, f := range zr.File {
+			if f.Name == zipXattrsName {
+				if stored, err = readZipEntryXattrs(f); err != nil {
+					printWarning("%s: extended attributes: %v", src, err)
+				}
+				continue
+			}
+			file = f
+			n++
+		}
+		if n != 1 {
+			return withExitCode(exitSource, fmt.Errorf("%s: expected a single file, found %d", src, n))
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return withExitCode(exitSource, err)
+		}
+		defer rc.Close()
+		if file.Mode()&fs.ModeSymlink != 0 {
+			target, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget))
+			if err != nil {
+				return withExitCode(exitSource, err)
+			}
+			return restoreLink(string(target), dst)
+		}
+		r = rc
+		fi = file.FileInfo()
+		xattrs = stored[file.Name]
+	} else {
+		if fi, err := os.Lstat(src); err == nil && isSymlink(fi) {
+			target, err := os.Readlink(src)
+			if err != nil {
+				return withExitCode(exitSource, err)
+			}
+			return restoreLink(target, dst)
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return withExitCode(exitSource, err)
+		}
+		defer f.Close()
+		if fi, err = f.Stat(); err != nil {
+			return withExitCode(exitSource, err)
+		}
+		xattrs = fileXattrs(src)
+		r = f
+	}
+
+	out, err := createLocal(dst)
+	if err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	// Runs of zeros become holes again, which brings back a sparse file
+	// that was stored in full.
+	sw := &sparseWriter{f: out.File}
+	_, err = copyBlocks(sw, r)
+	if err == nil {
+		err = sw.finish()
+	}
+	if err != nil {
+		out.abort()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	// The copy got the mode and times of the file it was made from.
+	if err := copyMetadata(dst, fi, xattrs); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	// Then --umask, --chmod and --chown change it.
+	if mode := restoreMode(fi.Mode()); mode != fi.Mode() {
+		if err := os.Chmod(dst, chmodBits(mode)); err != nil {
+			return withExitCode(exitDestination, err)
+		}
+	}
+	if chownTo != nil {
+		if err := overrideOwner(fileOwner(fi)).apply(dst); err != nil {
+			return withExitCode(exitDestination, err)
+		}
+	}
+	return nil
+}
+
+// restoreLink brings back a version that is a symlink.
+func restoreLink(target, dst string) error {
+	if err := makeLink(target, dst); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	symlinkMode string
+	dereference bool
+)
+
+// The ways of dealing with symlinks --symlinks takes.
+const (
+	symlinksPreserve = "preserve"
+	symlinksFollow   = "follow"
+	symlinksSkip     = "skip"
+)
+
+// maxLinkTarget bounds the link target read from a zip entry.
+const maxLinkTarget = 4096
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&symlinkMode, "symlinks", symlinksPreserve, "What to do with symlinks in the directories backed up: preserve them as links, follow them to store what they point to, or skip them; symlinks given as sources are followed unless skipped")
+	rootCmd.PersistentFlags().BoolVarP(&dereference, "dereference", "L", false, "Follow symlinks, the same as --symlinks follow")
+}
+
+func setSymlinks() error {
+	if dereference {
+		if symlinkMode == symlinksSkip {
+			return fmt.Errorf("--dereference cannot be used with --symlinks skip")
+		}
+		symlinkMode = symlinksFollow
+	}
+	switch symlinkMode {
+	case symlinksPreserve, symlinksFollow, symlinksSkip:
+		return nil
+	}
+	return fmt.Errorf("--symlinks: unknown mode %q, use preserve, follow or skip", symlinkMode)
+}
+
+func followSymlinks() bool {
+	return symlinkMode == symlinksFollow
+}
+
+func isSymlink(fi os.FileInfo) bool {
+	return fi.Mode()&os.ModeSymlink != 0
+}
+
+// statSource returns the file info of a file found below a source, of what
+// it points to only when symlinks are followed.
+func statSource(path string) (os.FileInfo, error) {
+	if followSymlinks() {
+		return os.Stat(longPath(path))
+	}
+	return os.Lstat(longPath(path))
+}
+
+// statArg returns the file info of a source named on the command line. Like
+// tar -H and cp, a symlink there is followed unless symlinks are skipped:
+// --symlinks preserve is for those found below the sources.
+func statArg(path string) (os.FileInfo, error) {
+	if symlinkMode == symlinksSkip {
+		return os.Lstat(longPath(path))
+	}
+	return os.Stat(longPath(path))
+}
+
+// copyLink makes dst a symlink to where the symlink src points.
+func copyLink(src, dst string) error {
+	target, err := os.Readlink(longPath(src))
+	if err != nil {
+		return withExitCode(exitSource, err)
+	}
+	if err := makeLink(target, dst); err != nil {
+		return withExitCode(exitDestination, err)
+	}
+	result.Files++
+	return nil
+}
+
+// makeLink makes path a symlink to target, replacing what is there only
+// once the link exists, like createLocal does for files.
+func makeLink(target, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp-%d", filepath.Base(path), os.Getpid()))
+	if err := os.Symlink(target, longPath(tmp)); err != nil {
+		return err
+	}
+	if err := os.Rename(longPath(tmp), longPath(path)); err != nil {
+		os.Remove(longPath(tmp))
+		return err
+	}
+	return nil
+}
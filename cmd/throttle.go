@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits for remote traffic, shared by all destinations of a run. nil means
+// unlimited.
+var (
+	uploadLimit   *rateLimiter
+	downloadLimit *rateLimiter
+)
+
+var ioLimit string
+
+// readLimit paces the reads of local files for --io-limit, shared by all
+// goroutines reading them. nil means unlimited.
+var readLimit *rateLimiter
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&ioLimit, "io-limit", "", "Limit how fast local files are read, e.g. 50M, to leave the disk to other programs")
+}
+
+// rateLimiter paces a byte stream to a fixed rate. Every transfer reserves
+// its slot on a virtual clock and sleeps until that slot has passed, so
+// concurrent users share the rate between them.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSec)}
+}
+
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// throttledReader reads in small steps so the limiter can pace it smoothly.
+type throttledReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+	n, err := t.r.Read(p)
+	t.l.wait(n)
+	return n, err
+}
+
+type throttledReadCloser struct {
+	throttledReader
+	io.Closer
+}
+
+func throttleReadCloser(rc io.ReadCloser, l *rateLimiter) io.ReadCloser {
+	if l == nil {
+		return rc
+	}
+	return &throttledReadCloser{throttledReader{rc, l}, rc}
+}
+
+// setBandwidthLimit parses --bwlimit, either a single rate for uploads or
+// "UP:DOWN" to limit downloads as well.
+func setBandwidthLimit(s string) error {
+	if s == "" {
+		return nil
+	}
+
+	up, down, _ := strings.Cut(s, ":")
+	if up != "" {
+		rate, err := parseSize(up)
+		if err != nil {
+			return fmt.Errorf("--bwlimit: %w", err)
+		}
+		uploadLimit = newRateLimiter(rate)
+	}
+	if down != "" {
+		rate, err := parseSize(down)
+		if err != nil {
+			return fmt.Errorf("--bwlimit: %w", err)
+		}
+		downloadLimit = newRateLimiter(rate)
+	}
+	return nil
+}
+
+// setIOLimit parses --io-limit.
+func setIOLimit() error {
+	if ioLimit == "" {
+		return nil
+	}
+	rate, err := parseSize(ioLimit)
+	if err != nil {
+		return fmt.Errorf("--io-limit: %w", err)
+	}
+	readLimit = newRateLimiter(rate)
+	return nil
+}
+
+// limitReads paces the reads from a local file r for --io-limit.
+func limitReads(r io.Reader) io.Reader {
+	if readLimit == nil {
+		return r
+	}
+	return &throttledReader{r, readLimit}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+)
+
+var (
+	stripPrefix string
+	addPrefix   string
+)
+
+// skipPrefix is why entries naming the stripped prefix, or a directory
+// it is in, are left out: they would be the top of the tree itself.
+const skipPrefix = "strip-prefix"
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&stripPrefix, "strip-prefix", "", "Drop this leading directory from entry names, when archiving and when restoring, e.g. home/alice")
+	rootCmd.PersistentFlags().StringVar(&addPrefix, "add-prefix", "", "Put entry names below this directory, when archiving and when restoring (after --strip-prefix)")
+}
+
+func transformingNames() bool {
+	return stripPrefix != "" || addPrefix != "" || normalizeForm != ""
+}
+
+// transformName applies --normalize, --strip-prefix and --add-prefix to
+// the entry name. Names outside the stripped prefix are kept, the prefix
+// itself becomes the top of the tree, "" without an added prefix. The
+// directories the prefix is in are dropped.
+func transformName(name string) (string, bool) {
+	name = normalizeName(name)
+	if p := strings.TrimSuffix(normalizeName(stripPrefix), "/"); p != "" {
+		if strings.HasPrefix(p, name+"/") {
+			return "", false
+		}
+		if name == p {
+			name = ""
+		} else if rest, ok := strings.CutPrefix(name, p+"/"); ok {
+			name = rest
+		}
+	}
+	if p := strings.TrimSuffix(normalizeName(addPrefix), "/"); p != "" {
+		if name == "" {
+			return p, true
+		}
+		name = p + "/" + name
+	}
+	return name, true
+}
+
+// renameArchive stores the entries under their transformed names. Without
+// an added prefix, the top of the tree has no entry of its own, and the
+// entries that would be it are counted as skipped.
+type renameArchive struct {
+	archiveWriter
+}
+
+func (a renameArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	h := *hdr
+	name, ok := transformName(hdr.Name)
+	if !ok || name == "" {
+		entrySkipped(hdr.Name, skipPrefix)
+		if r != nil {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}
+		return nil
+	}
+	h.Name = name
+	if h.Typeflag == tar.TypeLink {
+		h.Linkname, _ = transformName(hdr.Linkname)
+	}
+	return a.archiveWriter.writeEntry(&h, r)
+}
+
+// droppedName reports whether the entry name is left out of the archive
+// by renameArchive.
+func droppedName(name string) bool {
+	if !transformingNames() {
+		return false
+	}
+	name, ok := transformName(name)
+	return !ok || name == ""
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+)
+
+var (
+	applyUmask bool
+	// umask is the process umask, read once before restoring.
+	umask fs.FileMode
+)
+
+func init() {
+	restoreCmd.Flags().BoolVar(&applyUmask, "umask", false, "Take away the mode bits the umask does from restored files, instead of giving them their archived modes exactly")
+}
+
+// processUmask is the umask. Reading it sets it for a moment, so it is
+// read once at startup, before any goroutine writes files.
+var processUmask = readUmask()
+
+func setUmask() {
+	if applyUmask {
+		umask = processUmask
+	}
+}
+
+// restoreMode returns the mode a file archived with mode is restored with,
+// after --umask and --chmod.
+func restoreMode(mode fs.FileMode) fs.FileMode {
+	return applyChmod(mode &^ umask)
+}
+
+// chmodBits returns the bits of mode os.Chmod sets: the permissions and
+// the setuid, setgid and sticky bits.
+func chmodBits(mode fs.FileMode) fs.FileMode {
+	return mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// makeDir makes the restored directory path, and lets the owner write to
+// it when it is there already read-only, until setMetadata gives it its
+// mode once its contents are restored.
+func makeDir(path string) error {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&0o700 != 0o700 {
+		return os.Chmod(path, perm|0o700)
+	}
+	return nil
+}
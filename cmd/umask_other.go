@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cmd
+
+import "io/fs"
+
+func readUmask() fs.FileMode {
+	return 0
+}
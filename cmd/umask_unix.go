@@ -0,0 +1,15 @@
+//go:build unix
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// readUmask returns the umask, which can only be read by setting it.
+func readUmask() fs.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return fs.FileMode(mask) & fs.ModePerm
+}
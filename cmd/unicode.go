@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var normalizeForm string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&normalizeForm, "normalize", "", "Store and restore entry names in this Unicode form, nfc (as on Linux and Windows) or nfd (as on macOS), so names from either system match")
+}
+
+func setNormalize() error {
+	switch normalizeForm {
+	case "", "nfc", "nfd":
+		return nil
+	}
+	return fmt.Errorf("--normalize: unknown form %q, use nfc or nfd", normalizeForm)
+}
+
+// normalizeName returns name in the form --normalize asks for.
+func normalizeName(name string) string {
+	switch normalizeForm {
+	case "nfc":
+		return norm.NFC.String(name)
+	case "nfd":
+		return norm.NFD.String(name)
+	}
+	return name
+}
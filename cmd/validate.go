@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file and its profiles, without running anything",
+	Args:  cobra.NoArgs,
+	Run:   runConfigValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) {
+	root, path, err := readConfig()
+	if err != nil {
+		reportError(withExitCode(exitUsage, err))
+		return
+	}
+	if root == nil {
+		if path == "" {
+			printInfo("No config file is read")
+		} else if _, err := os.Stat(path); err == nil {
+			// Comments alone.
+			printSuccess("%s is valid", path)
+		} else {
+			printInfo("No config file at %s", path)
+		}
+		return
+	}
+	warnings, errs := validateConfig(root, path)
+	for _, w := range warnings {
+		printWarning("%s", w)
+	}
+	for _, err := range errs {
+		reportError(withExitCode(exitUsage, err))
+	}
+	if len(errs) == 0 {
+		printSuccess("%s is valid", path)
+	}
+}
+
+// configValidator collects what is wrong with a config file.
+type configValidator struct {
+	path     string
+	root     *yaml.Node
+	warnings []string
+	errs     []error
+}
+
+// validateConfig checks the config root read from path: the keys and the
+// shape and type of their values, in all profiles. Unknown keys are only
+// warned about, they may be for a newer bak.
+func validateConfig(root *yaml.Node, path string) (warnings []string, errs []error) {
+	v := &configValidator{path: path, root: root}
+	v.mapping(root, "")
+	if profiles := mapValue(root, "profiles"); profiles != nil {
+		v.profiles(profiles)
+	}
+	return v.warnings, v.errs
+}
+
+func (v *configValidator) errorf(n *yaml.Node, field, format string, a ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s:%d: %s: %s", v.path, n.Line, field, fmt.Sprintf(format, a...)))
+}
+
+// mapping checks the keys of the config root, or of the profile named
+// profile.
+func (v *configValidator) mapping(m *yaml.Node, profile string) {
+	field := func(key string) string {
+		if profile == "" {
+			return key
+		}
+		return "profiles." + profile + "." + key
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		if seen[key.Value] {
+			v.errorf(key, field(key.Value), "given twice")
+			continue
+		}
+		seen[key.Value] = true
+
+		switch key.Value {
+		case "sources":
+			if _, err := configValues(value); err != nil {
+				v.errorf(value, field(key.Value), "%v", err)
+			}
+			continue
+		case "pre", "post":
+			v.hooks(value, field(key.Value))
+			continue
+		case "profiles":
+			if profile == "" {
+				continue
+			}
+		case "extends":
+			if profile != "" {
+				v.extends(value, profile)
+				continue
+			}
+		}
+
+		f := lookupFlag(key.Value)
+		if f == nil {
+			v.warnings = append(v.warnings, fmt.Sprintf("%s:%d: %s: unknown key, ignoring it", v.path, key.Line, field(key.Value)))
+			continue
+		}
+		if err := checkFlagValue(f, value); err != nil {
+			v.errorf(value, field(key.Value), "%v", err)
+		}
+	}
+}
+
+func (v *configValidator) profiles(profiles *yaml.Node) {
+	if profiles.Kind != yaml.MappingNode {
+		v.errorf(profiles, "profiles", "expected a mapping of profile names to profiles")
+		return
+	}
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		name, p := profiles.Content[i].Value, profiles.Content[i+1]
+		if p.Kind != yaml.MappingNode {
+			v.errorf(p, "profiles."+name, "expected a mapping of flag names to values")
+			continue
+		}
+		v.mapping(p, name)
+	}
+}
+
+func (v *configValidator) hooks(value *yaml.Node, field string) {
+	var cmds []string
+	if value.Kind == yaml.ScalarNode {
+		cmds = []string{value.Value}
+	} else if err := value.Decode(&cmds); err != nil {
+		v.errorf(value, field, "expected a command or a list of them")
+		return
+	}
+	for _, c := range cmds {
+		if err := checkHook(c); err != nil {
+			v.errorf(value, field, "%v", err)
+		}
+	}
+}
+
+// extends checks that the profile a profile extends exists, and that
+// following them does not come back to it.
+func (v *configValidator) extends(value *yaml.Node, profile string) {
+	field := "profiles." + profile + ".extends"
+	profiles := mapValue(v.root, "profiles")
+	own := value
+	chain := []string{profile}
+	for value != nil {
+		if value.Kind != yaml.ScalarNode {
+			// Reported where the value is.
+			if len(chain) == 1 {
+				v.errorf(own, field, "expected the name of a profile")
+			}
+			return
+		}
+		name := value.Value
+		if name == profile {
+			v.errorf(own, field, "profile %q extends itself: %s -> %s", name, strings.Join(chain, " -> "), name)
+			return
+		}
+		if slices.Contains(chain, name) {
+			// A loop further on, reported for the profiles in it.
+			return
+		}
+		p := mapValue(profiles, name)
+		if p == nil {
+			if len(chain) == 1 {
+				v.errorf(own, field, "no profile %q", name)
+			}
+			return
+		}
+		if p.Kind != yaml.MappingNode {
+			return
+		}
+		chain = append(chain, name)
+		value = mapValue(p, "extends")
+	}
+}
+
+// checkFlagValue tells whether the config value n would do for the flag
+// f, without setting it. Values of the types pflag parses itself are
+// checked, the rest only by shape.
+func checkFlagValue(f *pflag.Flag, n *yaml.Node) error {
+	values, err := configValues(n)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Value.(pflag.SliceValue); ok {
+		return nil
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("takes a single value")
+	}
+
+	s := values[0]
+	switch f.Value.Type() {
+	case "bool":
+		_, err = strconv.ParseBool(s)
+	case "int", "count", "int64":
+		_, err = strconv.ParseInt(s, 0, 64)
+	case "uint", "uint64":
+		_, err = strconv.ParseUint(s, 0, 64)
+	case "float64":
+		_, err = strconv.ParseFloat(s, 64)
+	case "duration":
+		_, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", s, f.Value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// walkParallel is how many directories walkTree reads at once.
+const walkParallel = 8
+
+// walkTree walks the tree at root like filepath.Walk: fn sees the same
+// entries in the same lexical order, on the calling goroutine. Meanwhile
+// the directories below the one being walked are read and their entries
+// stat'ed in the background, so that the waits on a slow or networked
+// filesystem overlap.
+//
+// When symlinks are followed, fn sees what they point to and linked
+// directories are walked too, except for one that is its own ancestor: fn
+// sees that as the link.
+func walkTree(root string, fn filepath.WalkFunc) error {
+	info, err := statArg(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w := &treeWalker{sem: make(chan struct{}, walkParallel), follow: followSymlinks()}
+		var real []string
+		if w.follow {
+			real = w.realDir(root, root, nil, true)
+		}
+		err = w.walk(root, info, w.list(root), real, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// loopsWarned holds the links to an ancestor directory warned about, as the
+// sources may be walked more than once.
+var loopsWarned = map[string]bool{}
+
+type treeWalker struct {
+	sem    chan struct{}
+	follow bool
+}
+
+// dirListing is a directory read with the entries in it stat'ed.
+type dirListing struct {
+	dir     string
+	follow  bool
+	started bool
+	done    chan struct{}
+	names   []string
+	infos   []os.FileInfo
+	links   []bool
+	errs    []error
+	err     error
+}
+
+// list starts reading dir in the background when there is a free slot.
+// Otherwise it is read once it is needed.
+func (w *treeWalker) list(dir string) *dirListing {
+	l := &dirListing{dir: dir, follow: w.follow, done: make(chan struct{})}
+	select {
+	case w.sem <- struct{}{}:
+		l.started = true
+		go func() {
+			defer func() { <-w.sem }()
+			l.read()
+			close(l.done)
+		}()
+	default:
+	}
+	return l
+}
+
+func (l *dirListing) wait() {
+	if l.started {
+		<-l.done
+	} else {
+		l.read()
+	}
+}
+
+func (l *dirListing) read() {
+	f, err := os.Open(longPath(l.dir))
+	if err != nil {
+		l.err = err
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		l.err = err
+		return
+	}
+	slices.Sort(names)
+
+	l.names = names
+	l.infos = make([]os.FileInfo, len(names))
+	l.links = make([]bool, len(names))
+	l.errs = make([]error, len(names))
+	for i, name := range names {
+		file := longPath(filepath.Join(l.dir, name))
+		l.infos[i], l.errs[i] = os.Lstat(file)
+		if l.follow && l.errs[i] == nil && isSymlink(l.infos[i]) {
+			l.links[i] = true
+			l.infos[i], l.errs[i] = os.Stat(file)
+		}
+	}
+}
+
+// realDir returns the real paths of the directories from the root down to
+// path, given those down to its parent. A path that was reached through a
+// link is resolved, the others are joined to their parent. It returns nil
+// when path is one of its own ancestors.
+func (w *treeWalker) realDir(path, name string, parents []string, link bool) []string {
+	var real string
+	if len(parents) == 0 || link {
+		var err error
+		if real, err = filepath.EvalSymlinks(longPath(path)); err != nil {
+			real = path
+		}
+	} else {
+		real = filepath.Join(parents[len(parents)-1], name)
+	}
+	if slices.Contains(parents, real) {
+		return nil
+	}
+	return append(parents[:len(parents):len(parents)], real)
+}
+
+func (w *treeWalker) walk(path string, info os.FileInfo, l *dirListing, real []string, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	l.wait()
+	err := fn(path, info, l.err)
+	if l.err != nil || err != nil {
+		return err
+	}
+
+	// Read the subdirectories ahead, while the entries before them are
+	// walked.
+	subdirs := make([]*dirListing, len(l.names))
+	reals := make([][]string, len(l.names))
+	for i, fi := range l.infos {
+		if l.errs[i] != nil || !fi.IsDir() {
+			continue
+		}
+		file := filepath.Join(path, l.names[i])
+		if w.follow {
+			if reals[i] = w.realDir(file, l.names[i], real, l.links[i]); reals[i] == nil {
+				if !loopsWarned[file] {
+					loopsWarned[file] = true
+					printWarning("Not following %s, it links to a directory it is in", file)
+				}
+				l.infos[i], l.errs[i] = os.Lstat(longPath(file))
+				continue
+			}
+		}
+		subdirs[i] = w.list(file)
+	}
+
+	for i, name := range l.names {
+		file := filepath.Join(path, name)
+		if l.errs[i] != nil {
+			if err := fn(file, l.infos[i], l.errs[i]); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(file, l.infos[i], subdirs[i], reals[i], fn); err != nil {
+			if !l.infos[i].IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
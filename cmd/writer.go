@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync/atomic"
+)
+
+// archiveWriter stores entries in a backup archive. Entries are described
+// by tar headers whatever the format, since those carry the most metadata.
+type archiveWriter interface {
+	// writeEntry stores an entry. r supplies the contents of regular files
+	// and is nil for everything else.
+	writeEntry(hdr *tar.Header, r io.Reader) error
+	Close() error
+}
+
+// newArchiveWriter returns the writer for the archive format asked for.
+func newArchiveWriter(w io.Writer) archiveWriter {
+	if zipOutput {
+		return &zipArchive{zw: newZipWriter(w)}
+	}
+	// An index needs the stream cut into gzip members to point into.
+	var gz io.WriteCloser
+	if compressJobs() > 1 || writeIndex {
+		gz = newParallelGzip(w, compressJobs())
+	} else {
+		gz = newGzipWriter(w)
+	}
+	a := &tarArchive{gz: gz, count: &countingWriter{w: gz}}
+	a.tw = tar.NewWriter(a.count)
+	if writeIndex {
+		a.index = &archiveIndex{Version: 1, Block: gzipBlockSize}
+	}
+	return a
+}
+
+// newGzipWriter compresses to w at gzipLevel.
+func newGzipWriter(w io.Writer) *gzip.Writer {
+	gw, _ := gzip.NewWriterLevel(w, gzipLevel)
+	return gw
+}
+
+// newZipWriter returns a zip writer deflating at gzipLevel.
+func newZipWriter(w io.Writer) *zip.Writer {
+	zw := zip.NewWriter(w)
+	if gzipLevel != gzip.DefaultCompression {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, gzipLevel)
+		})
+	}
+	return zw
+}
+
+// tarArchive writes a gzip-compressed tar archive. With --index, it keeps
+// track of where the entries start in index.
+type tarArchive struct {
+	gz    io.WriteCloser
+	tw    *tar.Writer
+	count *countingWriter
+	index *archiveIndex
+}
+
+func (a *tarArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if a.index == nil {
+		return a.writeTarEntry(hdr, r)
+	}
+	ie, r, err := a.startIndexEntry(hdr, r)
+	if err != nil {
+		return err
+	}
+	if err := a.writeTarEntry(hdr, r); err != nil {
+		return err
+	}
+	a.endIndexEntry(ie)
+	return nil
+}
+
+func (a *tarArchive) writeTarEntry(hdr *tar.Header, r io.Reader) error {
+	if m, ok := hdr.PAXRecords[sparseMapKey]; ok && r != nil && hdr.Typeflag == tar.TypeReg {
+		data, err := parseSparseMap(m)
+		if err != nil {
+			return err
+		}
+		return a.writeSparse(hdr, data, r)
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r == nil || hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := copyBlocks(a.tw, r)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if err := a.gz.Close(); err != nil {
+		return err
+	}
+	if z, ok := a.gz.(*parallelGzip); ok && a.index != nil {
+		a.index.Members = z.members
+		a.index.Size = z.written
+	}
+	return nil
+}
+
+// zipArchive writes a zip archive, deflating every file. The extended
+// attributes of the entries are collected in xattrs and stored last.
+type zipArchive struct {
+	zw     *zip.Writer
+	xattrs map[string]map[string]string
+}
+
+func (a *zipArchive) writeEntry(hdr *tar.Header, r io.Reader) error {
+	header, err := zip.FileInfoHeader(hdr.FileInfo())
+	if err != nil {
+		return err
+	}
+
+	header.Name = hdr.Name
+	if x := headerXattrs(hdr); x != nil {
+		if a.xattrs == nil {
+			a.xattrs = make(map[string]map[string]string)
+		}
+		a.xattrs[hdr.Name] = x
+	}
+	if hdr.Uname != "" || hdr.Uid != 0 || hdr.Gid != 0 {
+		header.Extra = zipOwnerExtra(hdr.Uid, hdr.Gid)
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case hdr.Typeflag == tar.TypeSymlink:
+		// Zip stores the target of a symlink as its contents.
+		_, err = io.WriteString(w, hdr.Linkname)
+	case hdr.Typeflag == tar.TypeReg && r != nil:
+		_, err = copyBlocks(w, r)
+	}
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	if err := writeZipXattrs(a.zw, a.xattrs); err != nil {
+		return err
+	}
+	return a.zw.Close()
+}
+
+// gzipBlockSize is how much of the stream parallelGzip compresses at once.
+const gzipBlockSize = 1 << 20
+
+// parallelGzip compresses a stream on several goroutines. The stream is cut
+// into blocks that become gzip members of their own, which gzip readers
+// join back into one stream.
+type parallelGzip struct {
+	w      io.Writer
+	buf    []byte
+	queue  chan chan []byte
+	done   chan struct{}
+	err    error
+	failed atomic.Bool
+
+	// members holds where each gzip member starts, written how much was
+	// written in all.
+	members []int64
+	written int64
+}
+
+func newParallelGzip(w io.Writer, n int) *parallelGzip {
+	z := &parallelGzip{w: w, queue: make(chan chan []byte, n), done: make(chan struct{})}
+	go z.run()
+	return z
+}
+
+// run writes the compressed blocks in the order they were queued.
+func (z *parallelGzip) run() {
+	defer close(z.done)
+	for res := range z.queue {
+		b := <-res
+		if z.err != nil {
+			continue
+		}
+		z.members = append(z.members, z.written)
+		n, err := z.w.Write(b)
+		z.written += int64(n)
+		if err != nil {
+			z.err = err
+			z.failed.Store(true)
+		}
+	}
+}
+
+func (z *parallelGzip) Write(p []byte) (int, error) {
+	if z.failed.Load() {
+		return 0, z.err
+	}
+	z.buf = append(z.buf, p...)
+	for len(z.buf) >= gzipBlockSize {
+		z.compress(z.buf[:gzipBlockSize])
+		z.buf = append([]byte(nil), z.buf[gzipBlockSize:]...)
+	}
+	return len(p), nil
+}
+
+// compress queues block to be compressed on a goroutine of its own. It
+// waits while as many blocks as there are jobs are under way.
+func (z *parallelGzip) compress(block []byte) {
+	res := make(chan []byte, 1)
+	z.queue <- res
+	go func() {
+		var out bytes.Buffer
+		gw := newGzipWriter(&out)
+		gw.Write(block)
+		gw.Close()
+		res <- out.Bytes()
+	}()
+}
+
+func (z *parallelGzip) Close() error {
+	z.compress(z.buf)
+	z.buf = nil
+	close(z.queue)
+	<-z.done
+	return z.err
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+var storeXattrs bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&storeXattrs, "xattrs", true, "Store extended attributes (capabilities, SELinux labels, macOS quarantine flags...) and give them back on restore")
+}
+
+// paxXattrPrefix starts the PAX records that hold extended attributes, as
+// GNU tar and bsdtar write them.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// addXattrs stores the extended attributes, ACLs and streams of file in
+// the records of hdr. Attributes that cannot be read are left out with a
+// warning, the file itself is still worth having.
+func addXattrs(hdr *tar.Header, file string) {
+	xattrs := fileXattrs(file)
+	if len(xattrs) == 0 {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string, len(xattrs))
+	}
+	for k, v := range xattrs {
+		if name, ok := strings.CutPrefix(k, adsXattrPrefix); ok {
+			hdr.PAXRecords[paxADSPrefix+name] = v
+			continue
+		}
+		if !isACLXattr(k) {
+			hdr.PAXRecords[paxXattrPrefix+k] = v
+			continue
+		}
+		text, err := aclText([]byte(v))
+		if err != nil {
+			printWarning("%s: %s: %v", file, k, err)
+			continue
+		}
+		hdr.PAXRecords[aclRecords[k]] = text
+	}
+}
+
+// fileXattrs returns the extended attributes of file that are kept,
+// warning about those that cannot be read.
+func fileXattrs(file string) map[string]string {
+	if !storeXattrs && !storeACLs && !keepADS() {
+		return nil
+	}
+	xattrs, err := readXattrs(file)
+	if err != nil {
+		printWarning("%s: extended attributes: %v", file, err)
+	}
+	for k := range xattrs {
+		if !keepXattr(k) {
+			delete(xattrs, k)
+		}
+	}
+	return xattrs
+}
+
+// headerXattrs returns the extended attributes, ACLs and streams stored in
+// hdr.
+func headerXattrs(hdr *tar.Header) map[string]string {
+	xattrs := make(map[string]string)
+	for k, v := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(k, paxXattrPrefix); ok {
+			xattrs[name] = v
+		} else if name, ok := strings.CutPrefix(k, paxADSPrefix); ok {
+			xattrs[adsXattrPrefix+name] = v
+		}
+	}
+	for name, k := range aclRecords {
+		text, ok := hdr.PAXRecords[k]
+		if !ok {
+			continue
+		}
+		b, err := aclBinary(text)
+		if err != nil {
+			printWarning("%s: %v", hdr.Name, err)
+			continue
+		}
+		xattrs[name] = string(b)
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return xattrs
+}
+
+// isMetadataRecord reports whether the PAX record k holds an extended
+// attribute, an ACL, a stream or metadata of macOS.
+func isMetadataRecord(k string) bool {
+	switch k {
+	case paxACLAccess, paxACLDefault, paxCreationTime, paxFileFlags:
+		return true
+	}
+	return strings.HasPrefix(k, paxXattrPrefix) || strings.HasPrefix(k, paxADSPrefix)
+}
+
+// zipXattrsName is the entry a zip archive keeps the extended attributes of
+// its other entries in, which zip has no place for. It holds a JSON object
+// of entry names to attributes, whose values are base64 as they may be
+// binary.
+const zipXattrsName = ".bak-xattrs.json"
+
+// writeZipXattrs adds the entry of the extended attributes to zw, unless
+// there are none.
+func writeZipXattrs(zw *zip.Writer, xattrs map[string]map[string]string) error {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	raw := make(map[string]map[string][]byte, len(xattrs))
+	for name, attrs := range xattrs {
+		raw[name] = make(map[string][]byte, len(attrs))
+		for k, v := range attrs {
+			raw[name][k] = []byte(v)
+		}
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: zipXattrsName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(raw)
+}
+
+func readZipXattrs(r io.Reader) (map[string]map[string]string, error) {
+	var raw map[string]map[string][]byte
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]map[string]string, len(raw))
+	for name, attrs := range raw {
+		xattrs[name] = make(map[string]string, len(attrs))
+		for k, v := range attrs {
+			xattrs[name][k] = string(v)
+		}
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,6 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is what reading an attribute that is not there fails with.
+const errNoXattr = unix.ENODATA
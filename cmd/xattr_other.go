@@ -0,0 +1,17 @@
+//go:build !darwin && !linux && !windows
+
+package cmd
+
+import "errors"
+
+// readXattrs returns none, extended attributes are not read here.
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattrs(path string, xattrs map[string]string) error {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return errors.New("extended attributes are not supported on this platform")
+}
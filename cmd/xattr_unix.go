@@ -0,0 +1,88 @@
+//go:build darwin || linux
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path, not following
+// symlinks. File systems without them have none.
+func readXattrs(path string) (map[string]string, error) {
+	var names []byte
+	for {
+		n, err := unix.Llistxattr(path, nil)
+		if err != nil {
+			return nil, ignoreUnsupported(err)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		names = make([]byte, n)
+		n, err = unix.Llistxattr(path, names)
+		if errors.Is(err, unix.ERANGE) {
+			// More were added in between.
+			continue
+		}
+		if err != nil {
+			return nil, ignoreUnsupported(err)
+		}
+		names = names[:n]
+		break
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(bytes.TrimRight(names, "\x00"), []byte{0}) {
+		v, err := readXattr(path, string(name))
+		if errors.Is(err, errNoXattr) {
+			// Removed in between.
+			continue
+		}
+		if err != nil {
+			return xattrs, err
+		}
+		xattrs[string(name)] = v
+	}
+	return xattrs, nil
+}
+
+func readXattr(path, name string) (string, error) {
+	for {
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, n)
+		n, err = unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+// writeXattrs sets the extended attributes on path. All are tried, the
+// first failure is returned.
+func writeXattrs(path string, xattrs map[string]string) error {
+	var first error
+	for k, v := range xattrs {
+		if err := unix.Lsetxattr(path, k, []byte(v), 0); err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return first
+}
+
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procFindFirstStreamW = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	size int64
+	name [windows.MAX_PATH + 36]uint16
+}
+
+// readXattrs returns the alternate data streams of path with --ads, the
+// only attributes Windows has besides its ACLs.
+func readXattrs(path string) (map[string]string, error) {
+	if !keepADS() {
+		return nil, nil
+	}
+	path = longPath(path)
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	h, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(h) == windows.InvalidHandle {
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(h))
+
+	xattrs := make(map[string]string)
+	for {
+		// Streams are named like ":Zone.Identifier:$DATA", the contents
+		// of the file itself is the one without a name.
+		name := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.name[:]), ":"), ":$DATA")
+		switch {
+		case name == "":
+		case data.size > maxADS:
+			printWarning("%s: leaving out stream %s of %s", path, name, formatSize(data.size))
+		default:
+			v, err := os.ReadFile(path + ":" + name)
+			if err != nil {
+				return xattrs, err
+			}
+			xattrs[adsXattrPrefix+name] = string(v)
+		}
+
+		ok, _, err := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+				return xattrs, nil
+			}
+			return xattrs, err
+		}
+	}
+}
+
+// writeXattrs writes the streams among xattrs to path. Other attributes
+// cannot be set here. All are tried, the first failure is returned.
+func writeXattrs(path string, xattrs map[string]string) error {
+	var first error
+	for k, v := range xattrs {
+		name, ok := strings.CutPrefix(k, adsXattrPrefix)
+		var err error
+		if ok {
+			err = os.WriteFile(longPath(path)+":"+name, []byte(v), 0o644)
+		} else {
+			err = errors.New("extended attributes are not supported on Windows")
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return first
+}